@@ -0,0 +1,59 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package check
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// Request is what Concourse sends to check a resource
+type Request struct {
+	Source  resource.Source   `json:"source"`
+	Version *resource.Version `json:"version"`
+}
+
+// RunCommand reports no new versions, since events are only created by puts. If
+// source.preflight is set, the source is first validated and the preflight is run, so that
+// a misconfigured source shows up as a failing check rather than a failing put. With
+// source.tenants, the preflight is run against each tenant
+func RunCommand(ctx context.Context, stdin io.Reader, hc *http.Client) ([]resource.Version, error) {
+	var s Request
+
+	if err := json.NewDecoder(stdin).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	versions := []resource.Version{}
+	if !s.Source.Preflight || s.Source.ProxyAddress != "" {
+		return versions, nil
+	}
+
+	if err := s.Source.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(s.Source.Tenants) == 0 {
+		if err := wavefront.NewAPIClient(s.Source, hc).Preflight(ctx); err != nil {
+			return nil, err
+		}
+
+		return versions, nil
+	}
+
+	// a tenant failing the preflight is a problem whatever the tenant policy
+	for _, t := range s.Source.Tenants {
+		if err := wavefront.NewAPIClient(s.Source.ForTenant(t), hc).Preflight(ctx); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+
+	return versions, nil
+}
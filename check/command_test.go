@@ -0,0 +1,47 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package check_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vmware-tanzu/observability-event-resource/check"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
+)
+
+func TestCheck(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo", "api_token": "bar"}}`)
+
+	versions, err := check.RunCommand(context.Background(), stdin, &http.Client{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if versions == nil || len(versions) != 0 {
+		t.Fatalf("expected an empty list of versions, but got %v", versions)
+	}
+}
+
+func TestCheckPreflight(t *testing.T) {
+	ts := httptest.NewServer(fake.New(fake.WithToken("bar")))
+	defer ts.Close()
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": {"tenant_url": %q, "api_token": "bar", "preflight": true}}`, ts.URL))
+	if _, err := check.RunCommand(context.Background(), stdin, &http.Client{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": {"tenant_url": %q, "api_token": "wrong", "preflight": true}}`, ts.URL))
+	_, err := check.RunCommand(context.Background(), stdin, &http.Client{})
+	if !errors.Is(err, wavefront.ErrPreflightFailed) || !errors.Is(err, wavefront.ErrUnauthorized) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrUnauthorized, err)
+	}
+}
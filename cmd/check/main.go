@@ -3,10 +3,30 @@
 
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
 
-// Check is a no-op
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/check"
+	"github.com/vmware-tanzu/observability-event-resource/internal/cli"
+)
 
 func main() {
-	fmt.Println("[]")
+	fmt.Fprintln(os.Stderr, resource.AppVersion)
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	versions, err := check.RunCommand(ctx, os.Stdin, http.DefaultClient)
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	if err = json.NewEncoder(os.Stdout).Encode(versions); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,52 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
+)
+
+// failureFlags collects each -fail flag
+type failureFlags []fake.Failure
+
+func (f *failureFlags) String() string {
+	return fmt.Sprintf("%d failures", len(*f))
+}
+
+func (f *failureFlags) Set(value string) error {
+	failure, err := fake.ParseFailure([]byte(value))
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, failure)
+	return nil
+}
+
+func main() {
+	var failures failureFlags
+
+	addr := flag.String("addr", ":8080", "the address to listen on")
+	token := flag.String("token", "", "the API token to require; any token is accepted if empty")
+	latency := flag.Duration("latency", 0, "a delay to add to every request")
+	flag.Var(&failures, "fail", `a failure to inject, as JSON, such as '{"path": "/api/v2/event", "status": 429, "count": 2}'; may be repeated`)
+	flag.Parse()
+
+	fmt.Fprintln(os.Stderr, resource.AppVersion)
+
+	server := fake.New(fake.WithToken(*token), fake.WithLatency(*latency))
+	for _, f := range failures {
+		server.InjectFailure(f)
+	}
+
+	log.Printf("serving a fake Wavefront events API on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -12,6 +12,7 @@ import (
 
 	resource "github.com/vmware-tanzu/observability-event-resource"
 	"github.com/vmware-tanzu/observability-event-resource/in"
+	"github.com/vmware-tanzu/observability-event-resource/internal/cli"
 )
 
 func main() {
@@ -19,9 +20,12 @@ func main() {
 
 	fmt.Fprintln(os.Stderr, resource.AppVersion)
 
-	resp, err := in.RunCommand(os.Stdin, outputDirectory, http.DefaultClient)
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	resp, err := in.RunCommand(ctx, os.Stdin, outputDirectory, http.DefaultClient)
 	if err != nil {
-		log.Fatal(err)
+		cli.Fatal(err)
 	}
 
 	if err = json.NewEncoder(os.Stdout).Encode(resp); err != nil {
@@ -11,6 +11,7 @@ import (
 	"os"
 
 	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/internal/cli"
 	"github.com/vmware-tanzu/observability-event-resource/out"
 )
 
@@ -19,9 +20,12 @@ func main() {
 
 	fmt.Fprintln(os.Stderr, resource.AppVersion)
 
-	resp, err := out.RunCommand(os.Stdin, baseDirectory, http.DefaultClient, os.Getenv)
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	resp, err := out.RunCommand(ctx, os.Stdin, baseDirectory, http.DefaultClient, os.Getenv)
 	if err != nil {
-		log.Fatal(err)
+		cli.Fatal(err)
 	}
 
 	if err = json.NewEncoder(os.Stdout).Encode(resp); err != nil {
@@ -6,4 +6,5 @@ require (
 	github.com/cenkalti/backoff/v4 v4.1.1
 	github.com/drone/envsubst v1.0.3
 	github.com/mitchellh/pointerstructure v1.2.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,59 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package in
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// runChildren lists the children of the event identified by the version, that is, the
+// events whose parent-event-id annotation is its ID, and writes them to the following files:
+// * children.json - a JSON array of the child events
+// * children.csv - one row per child event, including its state
+func runChildren(ctx context.Context, client *wavefront.APIClient, s Request, outputDirectory string) (Response, error) {
+	parent, err := client.GetEvent(ctx, s.Version.ID)
+	if err != nil {
+		return Response{}, fmt.Errorf("error getting event data: %w", err)
+	}
+
+	since := parent.Start()
+	if since.IsZero() {
+		since = time.Now().Add(-DefaultHistoryRange)
+	}
+
+	children, err := client.FindChildEvents(ctx, s.Version.ID, since)
+	if err != nil {
+		return Response{}, fmt.Errorf("error finding child events: %w", err)
+	}
+
+	if err = writeEventsJSON(filepath.Join(outputDirectory, "children.json"), children); err != nil {
+		return Response{}, err
+	}
+
+	if err = writeEventsCSV(filepath.Join(outputDirectory, "children.csv"), children); err != nil {
+		return Response{}, err
+	}
+
+	ongoing := 0
+	for _, child := range children {
+		if child.RunningState != wavefront.StateEnded {
+			ongoing++
+		}
+	}
+
+	return Response{
+		Version: s.Version,
+		Metadata: resource.Metadata{
+			{Name: "children", Value: strconv.Itoa(len(children))},
+			{Name: "ongoing", Value: strconv.Itoa(ongoing)},
+		},
+	}, nil
+}
@@ -4,13 +4,15 @@
 package in
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
+	"time"
 
 	resource "github.com/vmware-tanzu/observability-event-resource"
 	"github.com/vmware-tanzu/observability-event-resource/wavefront"
@@ -20,7 +22,25 @@ import (
 // output the following files to the specified outputDirectory:
 // * id - contains the event ID
 // * event.json - contains the whole of the event JSON
-func RunCommand(stdin io.Reader, outputDirectory string, hc *http.Client) (Response, error) {
+// * name, state, start_time, end_time, duration_seconds, tags - one file per field
+// * annotations/ - a directory containing one file per annotation
+// * timeline - the event's checkpoints, one per line, in order
+// * event.env - a shell-sourceable file exporting the above
+// * event.yaml - the event as YAML, if params.format is "yaml"
+// * debug.log - the trace of every API request made, if source.debug is set
+// * tenants/<name>/ - the above files for the event in each tenant, if source.tenants is set
+//
+// If params.wait_for_state is set, the event will be polled until it reaches
+// that state or params.timeout elapses. If the version is a placeholder for an event a put
+// failed to record, only the id file is written.
+//
+// If params.mode is "history", every matching event in the requested time range
+// is exported instead. See runHistory. If params.mode is "children", the event's
+// child events are listed instead. See runChildren. If params.mode is "dora", a DORA
+// metrics report is written instead. See runDORA.
+//
+// The whole get, including any waiting, is abandoned once params.timeout elapses or ctx is done.
+func RunCommand(ctx context.Context, stdin io.Reader, outputDirectory string, hc *http.Client) (Response, error) {
 	var s Request
 
 	if err := json.NewDecoder(stdin).Decode(&s); err != nil {
@@ -31,33 +51,127 @@ func RunCommand(stdin io.Reader, outputDirectory string, hc *http.Client) (Respo
 		return Response{}, err
 	}
 
-	client := wavefront.NewAPIClient(s.Source, hc)
+	if err := s.Params.Validate(); err != nil {
+		return Response{}, err
+	}
+
+	if wavefront.IsPlaceholderEventID(s.Version.ID) && (s.Params.Mode == "" || s.Params.Mode == EVENT) {
+		return runPlaceholder(s, outputDirectory)
+	}
+
+	if s.Source.ProxyAddress != "" {
+		return runProxy(s, outputDirectory)
+	}
+
+	timeout, _ := s.Params.GetTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var opts []wavefront.Option
+	if s.Source.Debug {
+		// keep a copy of the trace with the output, so that later steps can inspect it
+		trace, err := os.Create(filepath.Join(outputDirectory, "debug.log"))
+		if err != nil {
+			return Response{}, fmt.Errorf("error creating debug log: %w", err)
+		}
+		defer trace.Close()
+
+		opts = append(opts, wavefront.WithDebugOutput(io.MultiWriter(os.Stderr, trace)))
+	}
+
+	if len(s.Source.Tenants) > 0 {
+		if s.Params.Mode == "" || s.Params.Mode == EVENT {
+			return runTenants(ctx, s, outputDirectory, hc, timeout, opts)
+		}
+
+		// the other modes report on the primary tenant
+		s.Source = s.Source.ForTenant(s.Source.Tenants[0])
+	}
+
+	client := wavefront.NewAPIClient(s.Source, hc, opts...)
+
+	switch s.Params.Mode {
+	case HISTORY:
+		return runHistory(ctx, client, s, outputDirectory)
+	case CHILDREN:
+		return runChildren(ctx, client, s, outputDirectory)
+	case DORA:
+		return runDORA(ctx, client, s, outputDirectory)
+	}
+
+	event, err := getEvent(ctx, client, s, timeout)
+	if err != nil {
+		return Response{}, fmt.Errorf("error getting event data: %w", err)
+	}
+
+	return writeEvent(s, outputDirectory, event)
+}
+
+// getEvent fetches the version's event, first waiting for params.wait_for_state if it is set
+func getEvent(ctx context.Context, client *wavefront.APIClient, s Request, timeout time.Duration) (*wavefront.Event, error) {
+	if s.Params.WaitForState != "" {
+		return client.WaitForEventState(ctx, s.Version.ID, s.Params.WaitForState, timeout)
+	}
 
-	eventJSON, err := client.GetEventJSON(s.Version.ID)
+	return client.GetEvent(ctx, s.Version.ID)
+}
+
+// runProxy recovers the event from a version created through a proxy. Because a proxy
+// cannot look events up, only the event mode without wait_for_state is supported
+func runProxy(s Request, outputDirectory string) (Response, error) {
+	if (s.Params.Mode != "" && s.Params.Mode != EVENT) || s.Params.WaitForState != "" {
+		return Response{}, fmt.Errorf("mode %q and wait_for_state are %w", s.Params.Mode, wavefront.ErrUnsupportedByProxy)
+	}
+
+	event, err := wavefront.DecodeProxyEventID(s.Version.ID)
 	if err != nil {
 		return Response{}, fmt.Errorf("error getting event data: %w", err)
 	}
 
-	if err = ioutil.WriteFile(filepath.Join(outputDirectory, "id"), []byte(s.Version.ID), 0644); err != nil {
+	return writeEvent(s, outputDirectory, event)
+}
+
+// runPlaceholder writes only the id of an event that a put failed to record, without
+// contacting the tenant, so that the get after the put succeeds, and ending the event later
+// is skipped
+func runPlaceholder(s Request, outputDirectory string) (Response, error) {
+	if err := ioutil.WriteFile(filepath.Join(outputDirectory, "id"), []byte(s.Version.ID), 0644); err != nil {
 		return Response{}, fmt.Errorf("error writing event id: %w", err)
 	}
 
+	return Response{
+		Version:  s.Version,
+		Metadata: resource.Metadata{{Name: "placeholder", Value: "the event was not recorded"}},
+	}, nil
+}
+
+// writeEvent writes the id, event.json, and the other per-event files, and returns the
+// version and metadata for the event
+func writeEvent(s Request, outputDirectory string, event *wavefront.Event) (Response, error) {
+	if err := ioutil.WriteFile(filepath.Join(outputDirectory, "id"), []byte(s.Version.ID), 0644); err != nil {
+		return Response{}, fmt.Errorf("error writing event id: %w", err)
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return Response{}, fmt.Errorf("error writing event data: %w", err)
+	}
+
 	if err = ioutil.WriteFile(filepath.Join(outputDirectory, "event.json"), eventJSON, 0644); err != nil {
 		return Response{}, fmt.Errorf("error writing event data: %w", err)
 	}
 
-	var event interface{}
-	if err = json.NewDecoder(bytes.NewBuffer(eventJSON)).Decode(&event); err != nil {
-		return Response{}, fmt.Errorf("error parsing event from response: %w", err)
+	if err = writeEventFiles(outputDirectory, s.Version.ID, event, s.Params.Format); err != nil {
+		return Response{}, err
 	}
 
-	metadata, err := wavefront.GetConcourseMetadata(event)
+	metadata, err := wavefront.GetConcourseMetadata(event, s.Source)
 	if err != nil {
 		return Response{}, fmt.Errorf("error calculating resource metadata: %w", err)
 	}
 
 	return Response{
-		Version:  resource.Version{ID: s.Version.ID},
+		Version:  s.Version,
 		Metadata: metadata,
 	}, nil
 }
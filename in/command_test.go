@@ -5,6 +5,7 @@ package in_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -18,13 +19,14 @@ import (
 	resource "github.com/vmware-tanzu/observability-event-resource"
 	"github.com/vmware-tanzu/observability-event-resource/in"
 	"github.com/vmware-tanzu/observability-event-resource/internal/testutils"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
 )
 
 func TestInvalidSource(t *testing.T) {
 	stdin := strings.NewReader("{}")
 	outputDirectory := "unused"
 
-	_, err := in.RunCommand(stdin, outputDirectory, nil)
+	_, err := in.RunCommand(context.Background(), stdin, outputDirectory, nil)
 	if err == nil {
 		t.Fatal("Expected an error to occur that never did")
 	}
@@ -34,7 +36,7 @@ func TestInvalidSource(t *testing.T) {
 	}
 
 	stdin = strings.NewReader(`{"source":{"tenant_url":"http://foo.com"}}`)
-	_, err = in.RunCommand(stdin, outputDirectory, nil)
+	_, err = in.RunCommand(context.Background(), stdin, outputDirectory, nil)
 	if err == nil {
 		t.Fatal("Expected an error to occur that never did")
 	}
@@ -50,7 +52,7 @@ func TestIn(t *testing.T) {
 	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/1234", "bar", fakeOngoingEventJSON)
 
 	tmpDir := t.TempDir()
-	resp, err := in.RunCommand(stdin, tmpDir, hc)
+	resp, err := in.RunCommand(context.Background(), stdin, tmpDir, hc)
 	if err != nil {
 		t.Fatalf("unexpected error %v", err)
 	}
@@ -59,8 +61,8 @@ func TestIn(t *testing.T) {
 		t.Fatalf("expected output version ID to be 1234, but it was %s", resp.Version.ID)
 	}
 
-	if len(resp.Metadata) != 2 {
-		t.Fatalf("expected 2 metadata but found %d", len(resp.Metadata))
+	if len(resp.Metadata) != 3 {
+		t.Fatalf("expected 3 metadata but found %d", len(resp.Metadata))
 	}
 
 	if resp.Metadata[0].Value != "some fake event" {
@@ -108,6 +110,418 @@ func TestIn(t *testing.T) {
 	}
 }
 
+func TestInDebugLog(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo", "api_token": "bar", "debug": true}, "version": {"id": "1234"}}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/1234", "bar", fakeOngoingEventJSON)
+
+	tmpDir := t.TempDir()
+	if _, err := in.RunCommand(context.Background(), stdin, tmpDir, hc); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	trace, err := ioutil.ReadFile(path.Join(tmpDir, "debug.log"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !strings.Contains(string(trace), `"url":"https://foo/api/v2/event/1234"`) || !strings.Contains(string(trace), `"status":200`) {
+		t.Fatalf("expected the request to be traced, but debug.log contained %s", trace)
+	}
+}
+
+func TestParamValidation(t *testing.T) {
+	p := in.Params{WaitForState: "done"}
+	if err := p.Validate(); err == nil {
+		t.Fatal("an expected error did not occur")
+	}
+
+	p.WaitForState = "ended"
+	if err := p.Validate(); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	p.Timeout = "soon"
+	if err := p.Validate(); err == nil {
+		t.Fatal("an expected error did not occur")
+	}
+
+	p.Timeout = "15m"
+	if err := p.Validate(); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+}
+
+func TestInWaitForState(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo", "api_token": "bar"}, "version": {"id": "1234"}, "params": {"wait_for_state": "ENDED", "timeout": "1m"}}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/1234", "bar", fakeEndedEventJSON)
+
+	resp, err := in.RunCommand(context.Background(), stdin, t.TempDir(), hc)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if resp.Metadata[1].Value != "ENDED" {
+		t.Fatalf("expected state to be ENDED, but it was %s", resp.Metadata[1].Value)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/1234"); count != 1 {
+		t.Fatalf("expected the event to be fetched once, but it was fetched %d times", count)
+	}
+}
+
+func TestInWaitForStateTimeout(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo", "api_token": "bar"}, "version": {"id": "1234"}, "params": {"wait_for_state": "ENDED", "timeout": "1s"}}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/1234", "bar", fakeOngoingEventJSON)
+
+	_, err := in.RunCommand(context.Background(), stdin, t.TempDir(), hc)
+	if !errors.Is(err, wavefront.ErrUnexpectedEventState) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrUnexpectedEventState, err)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/1234"); count < 2 {
+		t.Fatalf("expected the event to be polled more than once, but it was fetched %d times", count)
+	}
+}
+
+func TestProxyUnsupported(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"proxy_address": "localhost:2878"}, "version": {"id": "12345"}, "params": {"mode": "history"}}`)
+
+	_, err := in.RunCommand(context.Background(), stdin, t.TempDir(), nil)
+	if !errors.Is(err, wavefront.ErrUnsupportedByProxy) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrUnsupportedByProxy, err)
+	}
+}
+
+func TestInChildren(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo", "api_token": "bar"}, "version": {"id": "1"}, "params": {"mode": "children"}}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/1", "bar", `{"status": {}, "response": {"id": "1", "name": "Release v1.2", "runningState": "ONGOING", "startTime": 1604232000000}}`)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/search/event", "bar", fakeChildEventsJSON)
+
+	tmpDir := t.TempDir()
+	resp, err := in.RunCommand(context.Background(), stdin, tmpDir, hc)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expectedMetadata := resource.Metadata{
+		{Name: "children", Value: "2"},
+		{Name: "ongoing", Value: "1"},
+	}
+
+	if !reflect.DeepEqual(expectedMetadata, resp.Metadata) {
+		t.Fatalf("expected metadata to be %v, but it was %v", expectedMetadata, resp.Metadata)
+	}
+
+	csvBytes, err := ioutil.ReadFile(path.Join(tmpDir, "children.csv"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !strings.Contains(string(csvBytes), "3,Deploy to production,ONGOING") {
+		t.Fatalf("expected children.csv to list the ongoing child, but it was %q", string(csvBytes))
+	}
+
+	search := testutils.GetSentRequest(hc, "/api/v2/search/event")
+	if !strings.Contains(search, `{"key":"annotations.parent-event-id","value":"1","matchingMethod":"EXACT"}`) {
+		t.Fatalf("expected the children to be searched for by their parent annotation, but the search was %s", search)
+	}
+}
+
+const fakeChildEventsJSON = `
+{
+	"status": {},
+	"response": {
+		"moreItems": false,
+		"items": [
+			{"id": "2", "name": "Deploy to staging", "runningState": "ENDED", "annotations": {"parent-event-id": "1"}},
+			{"id": "3", "name": "Deploy to production", "runningState": "ONGOING", "annotations": {"parent-event-id": "1"}}
+		]
+	}
+}
+`
+
+func TestInOutputFiles(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo", "api_token": "bar"}, "version": {"id": "1234"}, "params": {"format": "yaml"}}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/1234", "bar", fakeDetailedEventJSON)
+
+	tmpDir := t.TempDir()
+	if _, err := in.RunCommand(context.Background(), stdin, tmpDir, hc); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expectedFiles := map[string]string{
+		"name":                  "some fake event",
+		"state":                 "ENDED",
+		"start_time":            "2020-11-01T12:00:00Z",
+		"end_time":              "2020-11-01T12:01:30Z",
+		"duration_seconds":      "90",
+		"tags":                  "tag1\ntag2",
+		"annotations/severity":  "info",
+		"annotations/it's":      "it's quoted",
+		"annotations/some_path": "slashed",
+		"timeline":              "2020-11-01T12:00:30Z migrations done\n2020-11-01T12:01:00Z traffic shifted\n",
+	}
+
+	for file, expected := range expectedFiles {
+		actual, err := ioutil.ReadFile(path.Join(tmpDir, file))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		if string(actual) != expected {
+			t.Fatalf("expected %s to contain %q, but it contained %q", file, expected, string(actual))
+		}
+	}
+
+	env, err := ioutil.ReadFile(path.Join(tmpDir, "event.env"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, line := range []string{
+		"export EVENT_DURATION_SECONDS='90'",
+		"export EVENT_TAGS='tag1,tag2'",
+		`export EVENT_ANNOTATION_IT_S='it'\''s quoted'`,
+		`export EVENT_ANNOTATION_SEVERITY='info'`,
+		`export EVENT_NAME='some fake event'`,
+	} {
+		if !strings.Contains(string(env), line+"\n") {
+			t.Fatalf("expected event.env to contain %q, but it was %q", line, string(env))
+		}
+	}
+
+	yamlBytes, err := ioutil.ReadFile(path.Join(tmpDir, "event.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if !strings.Contains(string(yamlBytes), "name: some fake event") {
+		t.Fatalf("unexpected value in event.yaml: %q", string(yamlBytes))
+	}
+}
+
+func TestInHistory(t *testing.T) {
+	stdin := strings.NewReader(`{
+		"source": {"tenant_url": "https://foo", "api_token": "bar"},
+		"version": {"id": "1234"},
+		"params": {
+			"mode": "history",
+			"from": "2020-11-01T00:00:00Z",
+			"to": "2020-11-02T00:00:00Z",
+			"filter": {"name": "deploy *", "tags": ["prod"]}
+		}
+	}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event", "bar", fakeEventListJSON)
+
+	tmpDir := t.TempDir()
+	resp, err := in.RunCommand(context.Background(), stdin, tmpDir, hc)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if resp.Metadata[0].Value != "2" {
+		t.Fatalf("expected 2 events to match, but %s did", resp.Metadata[0].Value)
+	}
+
+	csvBytes, err := ioutil.ReadFile(path.Join(tmpDir, "events.csv"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := "id,name,state,start_time,end_time,duration_seconds,severity,tags\n" +
+		"1,deploy api,ENDED,2020-11-01T12:00:00Z,2020-11-01T12:01:30Z,90,info,prod api\n" +
+		"3,deploy web,ONGOING,2020-11-01T13:00:00Z,,,,prod\n"
+	if string(csvBytes) != expected {
+		t.Fatalf("expected events.csv to be %q, but it was %q", expected, string(csvBytes))
+	}
+
+	var events []interface{}
+	jsonBytes, err := ioutil.ReadFile(path.Join(tmpDir, "events.json"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err = json.Unmarshal(jsonBytes, &events); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected events.json to contain 2 events, but it contained %d", len(events))
+	}
+}
+
+func TestInDORA(t *testing.T) {
+	stdin := strings.NewReader(`{
+		"source": {"tenant_url": "https://foo", "api_token": "bar"},
+		"version": {"id": "1234"},
+		"params": {
+			"mode": "dora",
+			"from": "2020-11-01T00:00:00Z",
+			"to": "2020-11-03T00:00:00Z",
+			"deployments": {"tags": ["deploy"]},
+			"incidents": {"name": "incident*"},
+			"send_metrics": true,
+			"metric_tags": {"team": "platform"}
+		}
+	}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event", "bar", fakeDORAEventListJSON)
+	testutils.AddSubRequest(hc, http.MethodPost, "/report", "bar", "")
+
+	tmpDir := t.TempDir()
+	resp, err := in.RunCommand(context.Background(), stdin, tmpDir, hc)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expectedMetadata := resource.Metadata{
+		{Name: "deployments", Value: "3"},
+		{Name: "deployment_frequency", Value: "1.50/day"},
+		{Name: "lead_time", Value: "1h30m0s"},
+		{Name: "change_failure_rate", Value: "33.3%"},
+		{Name: "mttr", Value: "30m0s"},
+	}
+
+	if !reflect.DeepEqual(expectedMetadata, resp.Metadata) {
+		t.Fatalf("expected metadata to be %v, but it was %v", expectedMetadata, resp.Metadata)
+	}
+
+	for _, file := range []string{"dora.json", "dora.md"} {
+		if _, err = ioutil.ReadFile(path.Join(tmpDir, file)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	sent := testutils.GetSentRequest(hc, "/report")
+	expectedLine := `dora.mttr_seconds 1800 1604361600 source="concourse" "team"="platform"`
+	if !strings.Contains(sent, expectedLine+"\n") {
+		t.Fatalf("expected metrics to contain %q, but they were %q", expectedLine, sent)
+	}
+}
+
+const fakeDORAEventListJSON = `
+{
+	"status": {},
+	"response": {
+		"moreItems": false,
+		"items": [
+			{
+				"id": "1",
+				"name": "deploy api",
+				"runningState": "ENDED",
+				"startTime": 1604232000000,
+				"endTime": 1604235600000,
+				"tags": ["deploy"],
+				"annotations": {"severity": "info", "commit_time": "2020-11-01T11:00:00Z"}
+			},
+			{
+				"id": "2",
+				"name": "deploy api",
+				"runningState": "ENDED",
+				"startTime": 1604318400000,
+				"endTime": 1604318460000,
+				"tags": ["deploy"],
+				"annotations": {"severity": "FAILED", "commit_time": "1604314860"}
+			},
+			{
+				"id": "3",
+				"name": "deploy web",
+				"runningState": "ONGOING",
+				"startTime": 1604320000000,
+				"tags": ["deploy"]
+			},
+			{
+				"id": "4",
+				"name": "incident: api down",
+				"runningState": "ENDED",
+				"startTime": 1604318500000,
+				"endTime": 1604320300000
+			}
+		]
+	}
+}
+`
+
+const fakeEventListJSON = `
+{
+	"status": {},
+	"response": {
+		"moreItems": false,
+		"items": [
+			{
+				"id": "1",
+				"name": "deploy api",
+				"runningState": "ENDED",
+				"startTime": 1604232000000,
+				"endTime": 1604232090000,
+				"tags": ["prod", "api"],
+				"annotations": {"severity": "info"}
+			},
+			{
+				"id": "2",
+				"name": "deploy api",
+				"runningState": "ENDED",
+				"startTime": 1604233000000,
+				"endTime": 1604233090000,
+				"tags": ["staging"]
+			},
+			{
+				"id": "3",
+				"name": "deploy web",
+				"runningState": "ONGOING",
+				"startTime": 1604235600000,
+				"tags": ["prod"]
+			},
+			{
+				"id": "4",
+				"name": "incident",
+				"runningState": "ONGOING",
+				"startTime": 1604235600000,
+				"tags": ["prod"]
+			}
+		]
+	}
+}
+`
+
+const fakeDetailedEventJSON = `
+{
+	"status": {},
+	"response": {
+		"id": "1234",
+		"name": "some fake event",
+		"runningState": "ENDED",
+		"startTime": 1604232000000,
+		"endTime": 1604232090000,
+		"tags": ["tag1", "tag2"],
+		"annotations": {
+			"severity": "info",
+			"it's": "it's quoted",
+			"some/path": "slashed",
+			"checkpoint-2": "2020-11-01T12:01:00Z traffic shifted",
+			"checkpoint-1": "2020-11-01T12:00:30Z migrations done"
+		}
+	}
+}
+`
+
+const fakeEndedEventJSON = `
+{
+	"status": {},
+	"response": {
+		"id": "1234",
+		"name": "some fake event",
+		"runningState": "ENDED"
+	}
+}
+`
+
 const fakeOngoingEventJSON = `
 {
 	"status": {},
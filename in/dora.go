@@ -0,0 +1,279 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package in
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// commitTimeAnnotation is read from deployment events to calculate lead time for changes
+const commitTimeAnnotation = "commit_time"
+
+// doraReport contains the four DORA metrics for a period. Metrics that could not be
+// calculated, for example lead time when no deployment has a commit_time annotation,
+// are nil
+type doraReport struct {
+	From                time.Time `json:"from"`
+	To                  time.Time `json:"to"`
+	Deployments         int       `json:"deployments"`
+	FailedDeployments   int       `json:"failed_deployments"`
+	Incidents           int       `json:"incidents"`
+	DeploymentFrequency float64   `json:"deployment_frequency_per_day"`
+	LeadTimeSeconds     *float64  `json:"lead_time_seconds,omitempty"`
+	ChangeFailureRate   *float64  `json:"change_failure_rate,omitempty"`
+	MTTRSeconds         *float64  `json:"mttr_seconds,omitempty"`
+}
+
+// runDORA reads the deployment and incident events between params.from and params.to,
+// calculates the DORA metrics, and writes them to the following files:
+// * dora.json - the report as JSON
+// * dora.md - the report as a Markdown table
+//
+// If params.send_metrics is set, the metrics are also sent to Wavefront
+func runDORA(ctx context.Context, client *wavefront.APIClient, s Request, outputDirectory string) (Response, error) {
+	from, to, err := s.Params.GetTimeRange(time.Now())
+	if err != nil {
+		return Response{}, err
+	}
+
+	events, err := client.ListEvents(ctx, from, to)
+	if err != nil {
+		return Response{}, fmt.Errorf("error listing events: %w", err)
+	}
+
+	deployments := wavefront.FilterEvents(events, s.Params.Deployments)
+
+	incidents := []wavefront.Event{}
+	if !s.Params.Incidents.IsEmpty() {
+		incidents = wavefront.FilterEvents(events, s.Params.Incidents)
+	}
+
+	report := calculateDORA(from, to, deployments, incidents, s.Params.FailureSeverities)
+
+	jsonBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return Response{}, fmt.Errorf("error converting report to json: %w", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(outputDirectory, "dora.json"), jsonBytes, 0644); err != nil {
+		return Response{}, fmt.Errorf("error writing dora.json: %w", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(outputDirectory, "dora.md"), report.markdown(), 0644); err != nil {
+		return Response{}, fmt.Errorf("error writing dora.md: %w", err)
+	}
+
+	if s.Params.SendMetrics {
+		prefix := s.Params.MetricPrefix
+		if prefix == "" {
+			prefix = DefaultMetricPrefix
+		}
+
+		if err = client.SendMetrics(ctx, report.points(prefix, s.Params.MetricTags)); err != nil {
+			return Response{}, fmt.Errorf("error sending DORA metrics: %w", err)
+		}
+	}
+
+	metadata := resource.Metadata{
+		{Name: "deployments", Value: strconv.Itoa(report.Deployments)},
+		{Name: "deployment_frequency", Value: fmt.Sprintf("%.2f/day", report.DeploymentFrequency)},
+	}
+
+	if report.LeadTimeSeconds != nil {
+		metadata = append(metadata, resource.Metadatum{Name: "lead_time", Value: secondsString(*report.LeadTimeSeconds)})
+	}
+
+	if report.ChangeFailureRate != nil {
+		metadata = append(metadata, resource.Metadatum{Name: "change_failure_rate", Value: fmt.Sprintf("%.1f%%", *report.ChangeFailureRate*100)})
+	}
+
+	if report.MTTRSeconds != nil {
+		metadata = append(metadata, resource.Metadatum{Name: "mttr", Value: secondsString(*report.MTTRSeconds)})
+	}
+
+	return Response{
+		Version:  s.Version,
+		Metadata: metadata,
+	}, nil
+}
+
+func calculateDORA(from time.Time, to time.Time, deployments []wavefront.Event, incidents []wavefront.Event, failureSeverities []string) doraReport {
+	report := doraReport{
+		From:        from.UTC(),
+		To:          to.UTC(),
+		Deployments: len(deployments),
+		Incidents:   len(incidents),
+	}
+
+	report.DeploymentFrequency = float64(len(deployments)) / (to.Sub(from).Hours() / 24)
+
+	var leadTimes []float64
+	for _, d := range deployments {
+		if wavefront.IsFailureSeverity(d.Annotations["severity"], failureSeverities) {
+			report.FailedDeployments++
+		}
+
+		commitTime, ok := parseCommitTime(d.Annotations[commitTimeAnnotation])
+		if !ok {
+			continue
+		}
+
+		deployedAt := d.End()
+		if deployedAt.IsZero() {
+			deployedAt = d.Start()
+		}
+
+		if !deployedAt.IsZero() && deployedAt.After(commitTime) {
+			leadTimes = append(leadTimes, deployedAt.Sub(commitTime).Seconds())
+		}
+	}
+
+	if len(leadTimes) > 0 {
+		leadTime := median(leadTimes)
+		report.LeadTimeSeconds = &leadTime
+	}
+
+	// a deployment counts as failed by its own severity, since incidents carry nothing that
+	// links them to the deployment that caused them
+	if len(deployments) > 0 {
+		rate := float64(report.FailedDeployments) / float64(len(deployments))
+		report.ChangeFailureRate = &rate
+	}
+
+	var restoreTotal float64
+	restored := 0
+	for _, i := range incidents {
+		start, end := i.Start(), i.End()
+		if start.IsZero() || end.IsZero() {
+			continue
+		}
+
+		restoreTotal += end.Sub(start).Seconds()
+		restored++
+	}
+
+	if restored > 0 {
+		mttr := restoreTotal / float64(restored)
+		report.MTTRSeconds = &mttr
+	}
+
+	return report
+}
+
+// parseCommitTime accepts an RFC 3339 timestamp, or seconds or milliseconds since the epoch
+func parseCommitTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || epoch <= 0 {
+		return time.Time{}, false
+	}
+
+	// anything after the year 33658 in seconds is assumed to be in milliseconds
+	if epoch > 1e12 {
+		return time.Unix(0, epoch*int64(time.Millisecond)), true
+	}
+
+	return time.Unix(epoch, 0), true
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	return sorted[mid]
+}
+
+func secondsString(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func (r doraReport) points(prefix string, tags map[string]string) []wavefront.Point {
+	values := map[string]float64{
+		"deployments":                  float64(r.Deployments),
+		"deployment_frequency_per_day": r.DeploymentFrequency,
+		"incidents":                    float64(r.Incidents),
+	}
+
+	if r.LeadTimeSeconds != nil {
+		values["lead_time_seconds"] = *r.LeadTimeSeconds
+	}
+
+	if r.ChangeFailureRate != nil {
+		values["change_failure_rate"] = *r.ChangeFailureRate
+	}
+
+	if r.MTTRSeconds != nil {
+		values["mttr_seconds"] = *r.MTTRSeconds
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	points := make([]wavefront.Point, len(names))
+	for i, name := range names {
+		points[i] = wavefront.Point{
+			Metric:    prefix + "." + name,
+			Value:     values[name],
+			Timestamp: r.To,
+			Tags:      tags,
+		}
+	}
+
+	return points
+}
+
+func (r doraReport) markdown() []byte {
+	notAvailable := "n/a"
+
+	leadTime, failureRate, mttr := notAvailable, notAvailable, notAvailable
+	if r.LeadTimeSeconds != nil {
+		leadTime = secondsString(*r.LeadTimeSeconds)
+	}
+
+	if r.ChangeFailureRate != nil {
+		failureRate = fmt.Sprintf("%.1f%% (%d of %d)", *r.ChangeFailureRate*100, r.FailedDeployments, r.Deployments)
+	}
+
+	if r.MTTRSeconds != nil {
+		mttr = secondsString(*r.MTTRSeconds)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# DORA metrics\n\n")
+	fmt.Fprintf(buf, "%s to %s\n\n", r.From.Format(time.RFC3339), r.To.Format(time.RFC3339))
+	fmt.Fprintf(buf, "| Metric | Value |\n")
+	fmt.Fprintf(buf, "| --- | --- |\n")
+	fmt.Fprintf(buf, "| Deployment frequency | %.2f per day (%d deployments) |\n", r.DeploymentFrequency, r.Deployments)
+	fmt.Fprintf(buf, "| Lead time for changes | %s |\n", leadTime)
+	fmt.Fprintf(buf, "| Change failure rate | %s |\n", failureRate)
+	fmt.Fprintf(buf, "| Time to restore service | %s (%d incidents) |\n", mttr, r.Incidents)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package in
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+var csvHeader = []string{"id", "name", "state", "start_time", "end_time", "duration_seconds", "severity", "tags"}
+
+// runHistory lists every event that started between params.from and params.to and
+// matches params.filter, and writes them to the following files:
+// * events.json - a JSON array of the matching events
+// * events.csv - one row per matching event, including its duration
+func runHistory(ctx context.Context, client *wavefront.APIClient, s Request, outputDirectory string) (Response, error) {
+	from, to, err := s.Params.GetTimeRange(time.Now())
+	if err != nil {
+		return Response{}, err
+	}
+
+	events, err := client.ListEvents(ctx, from, to)
+	if err != nil {
+		return Response{}, fmt.Errorf("error listing events: %w", err)
+	}
+
+	events = wavefront.FilterEvents(events, s.Params.Filter)
+
+	if err = writeEventsJSON(filepath.Join(outputDirectory, "events.json"), events); err != nil {
+		return Response{}, err
+	}
+
+	if err = writeEventsCSV(filepath.Join(outputDirectory, "events.csv"), events); err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		Version: s.Version,
+		Metadata: resource.Metadata{
+			{Name: "events", Value: strconv.Itoa(len(events))},
+			{Name: "from", Value: formatTime(from)},
+			{Name: "to", Value: formatTime(to)},
+		},
+	}, nil
+}
+
+func writeEventsJSON(file string, events []wavefront.Event) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("error writing events.json: %w", err)
+	}
+	defer f.Close()
+
+	if err = json.NewEncoder(f).Encode(events); err != nil {
+		return fmt.Errorf("error writing events.json: %w", err)
+	}
+
+	return f.Close()
+}
+
+func writeEventsCSV(file string, events []wavefront.Event) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("error writing events.csv: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err = w.Write(csvHeader); err != nil {
+		return fmt.Errorf("error writing events.csv: %w", err)
+	}
+
+	for _, event := range events {
+		if err = w.Write(csvRow(event)); err != nil {
+			return fmt.Errorf("error writing events.csv: %w", err)
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return fmt.Errorf("error writing events.csv: %w", err)
+	}
+
+	return f.Close()
+}
+
+func csvRow(event wavefront.Event) []string {
+	start, end := event.Start(), event.End()
+
+	return []string{event.ID, event.Name, event.RunningState, formatTime(start), formatTime(end), durationSeconds(start, end), event.Annotations["severity"], strings.Join(event.Tags, " ")}
+}
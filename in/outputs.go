@@ -0,0 +1,165 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package in
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"gopkg.in/yaml.v2"
+)
+
+// writeEventFiles writes the per-field files, the annotations directory, the timeline of
+// checkpoints, and event.env for the given event into outputDirectory
+func writeEventFiles(outputDirectory string, id string, event *wavefront.Event, format OutputFormat) error {
+	name, state, tags, annotations := event.Name, event.RunningState, event.Tags, event.Annotations
+	start, end := event.Start(), event.End()
+
+	duration := durationSeconds(start, end)
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"name", name},
+		{"state", state},
+		{"start_time", formatTime(start)},
+		{"end_time", formatTime(end)},
+		{"duration_seconds", duration},
+		{"tags", strings.Join(tags, "\n")},
+	}
+
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(outputDirectory, f.name), []byte(f.content), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %w", f.name, err)
+		}
+	}
+
+	annotationsDir := filepath.Join(outputDirectory, "annotations")
+	if err := os.MkdirAll(annotationsDir, 0755); err != nil {
+		return fmt.Errorf("error creating annotations directory: %w", err)
+	}
+
+	for k, v := range annotations {
+		if err := ioutil.WriteFile(filepath.Join(annotationsDir, annotationFileName(k)), []byte(v), 0644); err != nil {
+			return fmt.Errorf("error writing annotation %s: %w", k, err)
+		}
+	}
+
+	env := map[string]string{
+		"EVENT_ID":               id,
+		"EVENT_NAME":             name,
+		"EVENT_STATE":            state,
+		"EVENT_START_TIME":       formatTime(start),
+		"EVENT_END_TIME":         formatTime(end),
+		"EVENT_DURATION_SECONDS": duration,
+		"EVENT_TAGS":             strings.Join(tags, ","),
+	}
+
+	for k, v := range annotations {
+		env["EVENT_ANNOTATION_"+envVarName(k)] = v
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outputDirectory, "event.env"), formatEnvFile(env), 0644); err != nil {
+		return fmt.Errorf("error writing event.env: %w", err)
+	}
+
+	timelineBuf := &bytes.Buffer{}
+	for _, c := range event.Timeline() {
+		fmt.Fprintln(timelineBuf, c.String())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outputDirectory, "timeline"), timelineBuf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing timeline: %w", err)
+	}
+
+	if format == YAML {
+		// JSON is YAML, so reading the event's JSON keeps the API's field names
+		jsonBytes, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("error converting event to yaml: %w", err)
+		}
+
+		var fields yaml.MapSlice
+		if err = yaml.Unmarshal(jsonBytes, &fields); err != nil {
+			return fmt.Errorf("error converting event to yaml: %w", err)
+		}
+
+		yamlBytes, err := yaml.Marshal(fields)
+		if err != nil {
+			return fmt.Errorf("error converting event to yaml: %w", err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(outputDirectory, "event.yaml"), yamlBytes, 0644); err != nil {
+			return fmt.Errorf("error writing event.yaml: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// durationSeconds returns the whole seconds between start and end, or "" if either is unset
+func durationSeconds(start time.Time, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(int64(end.Sub(start)/time.Second), 10)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// annotationFileName makes an annotation key safe to use as a file name
+func annotationFileName(key string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(key)
+	if name == "" || name == "." || name == ".." {
+		name = strings.Repeat("_", len(name)+1)
+	}
+
+	return name
+}
+
+// envVarName converts an annotation key into a valid shell variable name suffix
+func envVarName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+func formatEnvFile(env map[string]string) []byte {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	for _, k := range keys {
+		fmt.Fprintf(buf, "export %s='%s'\n", k, strings.ReplaceAll(env[k], "'", `'\''`))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package in
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// runTenants gets the event from each tenant in the version's tenant_ids, and writes each
+// one's files to tenants/<name>. The files of the first of them, whose ID is the version's,
+// are also written to outputDirectory, as for a single tenant
+func runTenants(ctx context.Context, s Request, outputDirectory string, hc *http.Client, timeout time.Duration, opts []wavefront.Option) (Response, error) {
+	ids, err := s.Version.ParseTenantIDs()
+	if err != nil {
+		return Response{}, fmt.Errorf("error getting event data: %w", err)
+	}
+
+	var (
+		primary      *wavefront.Event
+		primaryInput Request
+	)
+
+	for _, t := range s.Source.Tenants {
+		id, ok := ids[t.Name]
+		if !ok {
+			continue
+		}
+
+		tenant := s
+		tenant.Source = s.Source.ForTenant(t)
+		tenant.Version.ID = id
+		tenant.Version.TenantIDs = ""
+
+		event, err := getEvent(ctx, wavefront.NewAPIClient(tenant.Source, hc, opts...), tenant, timeout)
+		if err != nil {
+			return Response{}, fmt.Errorf("error getting event data from tenant %s: %w", t.Name, err)
+		}
+
+		dir := filepath.Join(outputDirectory, "tenants", t.Name)
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return Response{}, fmt.Errorf("error creating tenant directory: %w", err)
+		}
+
+		if _, err = writeEvent(tenant, dir, event); err != nil {
+			return Response{}, err
+		}
+
+		if primary == nil {
+			primary = event
+			primaryInput = s
+			primaryInput.Source = tenant.Source
+		}
+	}
+
+	if primary == nil {
+		return Response{}, fmt.Errorf("error getting event data: version %q has an ID in none of the source's tenants", s.Version.TenantIDs)
+	}
+
+	return writeEvent(primaryInput, outputDirectory, primary)
+}
@@ -3,10 +3,172 @@
 
 package in
 
-import resource "github.com/vmware-tanzu/observability-event-resource"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
-// Params is empty because an observability event get takes no parameters
-type Params struct{}
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// DefaultWaitTimeout limits how long a get may take, including waiting for wait_for_state,
+// when timeout is not set
+const DefaultWaitTimeout = time.Hour
+
+// DefaultHistoryRange is how far back history mode looks when from is not set
+const DefaultHistoryRange = 7 * 24 * time.Hour
+
+// Params configures how an observability event get behaves
+type Params struct {
+	Mode         GetMode               `json:"mode,omitempty"`
+	WaitForState string                `json:"wait_for_state,omitempty"`
+	Timeout      string                `json:"timeout,omitempty"`
+	Format       OutputFormat          `json:"format,omitempty"`
+	Filter       wavefront.EventFilter `json:"filter,omitempty"`
+	From         string                `json:"from,omitempty"`
+	To           string                `json:"to,omitempty"`
+
+	Deployments       wavefront.EventFilter `json:"deployments,omitempty"`
+	Incidents         wavefront.EventFilter `json:"incidents,omitempty"`
+	FailureSeverities []string              `json:"failure_severities,omitempty"`
+	SendMetrics       bool                  `json:"send_metrics,omitempty"`
+	MetricPrefix      string                `json:"metric_prefix,omitempty"`
+	MetricTags        map[string]string     `json:"metric_tags,omitempty"`
+}
+
+// GetMode selects what a get step fetches
+type GetMode string
+
+const (
+	// EVENT fetches the single event identified by the version, and is the default
+	EVENT GetMode = "event"
+
+	// HISTORY lists every event matching params.filter between params.from and params.to
+	HISTORY GetMode = "history"
+
+	// CHILDREN lists the events whose parent is the event identified by the version
+	CHILDREN GetMode = "children"
+
+	// DORA computes DORA metrics from the deployment and incident events between params.from and params.to
+	DORA GetMode = "dora"
+)
+
+// DefaultMetricPrefix is prepended to the names of the metrics sent by DORA mode
+const DefaultMetricPrefix = "dora"
+
+// OutputFormat selects an additional representation of the event to write
+type OutputFormat string
+
+const (
+	// JSON only writes event.json, and is the default
+	JSON OutputFormat = "json"
+
+	// YAML writes event.yaml in addition to event.json
+	YAML OutputFormat = "yaml"
+)
+
+// Validate will ensure that the properties in a get's "params" block are well formed
+func (p Params) Validate() error {
+	switch p.Mode {
+	case "", EVENT, HISTORY, CHILDREN, DORA:
+	default:
+		return fmt.Errorf(`invalid mode %s, must be "event", "history", "children", or "dora"`, p.Mode)
+	}
+
+	if p.WaitForState != "" {
+		switch strings.ToUpper(p.WaitForState) {
+		case "ONGOING", "PENDING", "ENDED":
+		default:
+			return fmt.Errorf(`invalid wait_for_state %s, must be one of "ONGOING", "PENDING", or "ENDED"`, p.WaitForState)
+		}
+	}
+
+	if p.Format != "" && p.Format != JSON && p.Format != YAML {
+		return fmt.Errorf(`invalid format %s, must be "json" or "yaml"`, p.Format)
+	}
+
+	if _, err := p.GetTimeout(); err != nil {
+		return err
+	}
+
+	for _, f := range []wavefront.EventFilter{p.Filter, p.Deployments, p.Incidents} {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if p.Mode == DORA && p.Deployments.IsEmpty() {
+		return errors.New(`the "deployments" parameter must be set when "mode" is "dora"`)
+	}
+
+	if _, _, err := p.GetTimeRange(time.Now()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetTimeRange parses the from and to parameters relative to now. Each may be an RFC 3339
+// timestamp, or a duration such as 24h meaning that long before now. to defaults to now, and
+// from defaults to DefaultHistoryRange before to
+func (p Params) GetTimeRange(now time.Time) (time.Time, time.Time, error) {
+	to, err := parseTimeParam("to", p.To, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	from, err := parseTimeParam("from", p.From, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if p.From == "" {
+		from = to.Add(-DefaultHistoryRange)
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid time range: from (%s) must be before to (%s)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	return from, to, nil
+}
+
+func parseTimeParam(name string, value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	ago, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %s: must be an RFC 3339 timestamp or a duration", name, value)
+	}
+
+	return now.Add(-ago), nil
+}
+
+// GetTimeout parses the timeout parameter, returning DefaultWaitTimeout if it was not set
+func (p Params) GetTimeout() (time.Duration, error) {
+	if p.Timeout == "" {
+		return DefaultWaitTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(p.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %s: %w", p.Timeout, err)
+	}
+
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %s: must be positive", p.Timeout)
+	}
+
+	return timeout, nil
+}
 
 // Request is what is received on stdin from the pipeline
 type Request struct {
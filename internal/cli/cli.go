@@ -0,0 +1,40 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cli holds what the check, in and out commands share
+package cli
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// Context returns a context that is cancelled when the process is signalled to stop.
+// Concourse sends SIGTERM when a build is aborted, so this stops any in-flight requests
+func Context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	return ctx, cancel
+}
+
+// Fatal logs err and exits. If the error has a hint, it is logged as well, to point at the
+// likely fix rather than leaving the user to decode the API's response
+func Fatal(err error) {
+	if hint := wavefront.Hint(err); hint != "" {
+		log.Fatalf("%v\nhint: %s", err, hint)
+	}
+
+	log.Fatal(err)
+}
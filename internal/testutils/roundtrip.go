@@ -9,20 +9,19 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
-
-	"github.com/vmware-tanzu/observability-event-resource/wavefront"
 )
 
 type request struct {
-	method        string
-	token         string
-	response      string
-	requestString string
+	token    string
+	response string
 }
 
+// fakeRoundTripper serves canned responses by path and method, and records the last body
+// sent to each path
 type fakeRoundTripper struct {
-	allowedURLs map[string]*request
+	allowedURLs map[string]map[string]*request
 	urlCounts   map[string]int
+	sent        map[string]string
 }
 
 func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -38,13 +37,14 @@ func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 
 	f.urlCounts[req.URL.Path]++
 
-	r, ok := f.allowedURLs[req.URL.Path]
+	methods, ok := f.allowedURLs[req.URL.Path]
 	if !ok {
 		recorder.Code = http.StatusNotFound
 		return recorder.Result(), nil
 	}
 
-	if r.method != req.Method {
+	r, ok := methods[req.Method]
+	if !ok {
 		recorder.Code = http.StatusMethodNotAllowed
 		return recorder.Result(), nil
 	}
@@ -60,7 +60,9 @@ func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 		b := &bytes.Buffer{}
 		io.Copy(b, req.Body)
 
-		r.requestString = b.String()
+		if b.Len() > 0 {
+			f.sent[req.URL.Path] = b.String()
+		}
 	}
 
 	recorder.Code = http.StatusOK
@@ -70,27 +72,19 @@ func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 
 func GetFakeHTTPClient(method, path, token, response string) *http.Client {
 	f := &fakeRoundTripper{
-		allowedURLs: map[string]*request{},
+		allowedURLs: map[string]map[string]*request{},
 		urlCounts:   map[string]int{},
+		sent:        map[string]string{},
 	}
 
-	f.addSubRequest(method, path, token, "", response)
-
-	hc := http.DefaultClient
-	hc.Transport = f
+	f.addSubRequest(method, path, token, response)
 
-	return hc
+	return &http.Client{Transport: f}
 }
 
+// GetSentRequest returns the last non-empty body sent to the path
 func GetSentRequest(hc *http.Client, url string) string {
-	f := getRoundTripperFromClient(hc)
-
-	r, ok := f.allowedURLs[url]
-	if !ok {
-		return ""
-	}
-
-	return r.requestString
+	return getRoundTripperFromClient(hc).sent[url]
 }
 
 func GetURLHitCount(hc *http.Client, url string) int {
@@ -104,28 +98,22 @@ func GetURLHitCount(hc *http.Client, url string) int {
 
 func AddSubRequest(hc *http.Client, method, path, token, response string) {
 	f := getRoundTripperFromClient(hc)
-	f.addSubRequest(method, path, token, "", response)
+	f.addSubRequest(method, path, token, response)
 }
 
-func (f *fakeRoundTripper) addSubRequest(method, path, token, requestString, response string) {
-	f.allowedURLs[path] = &request{
-		method:        method,
-		token:         token,
-		requestString: requestString,
-		response:      response,
+// addSubRequest serves the response for the method and path, alongside any other methods
+// already served for the path
+func (f *fakeRoundTripper) addSubRequest(method, path, token, response string) {
+	if f.allowedURLs[path] == nil {
+		f.allowedURLs[path] = map[string]*request{}
 	}
-}
 
-func getRoundTripperFromClient(hc *http.Client) *fakeRoundTripper {
-	var f *fakeRoundTripper
-
-	switch hc.Transport.(type) {
-	case *fakeRoundTripper:
-		f = hc.Transport.(*fakeRoundTripper)
-	case *wavefront.AuthRoundTripper:
-		a := hc.Transport.(*wavefront.AuthRoundTripper)
-		f = a.GetDelegate().(*fakeRoundTripper)
+	f.allowedURLs[path][method] = &request{
+		token:    token,
+		response: response,
 	}
+}
 
-	return f
+func getRoundTripperFromClient(hc *http.Client) *fakeRoundTripper {
+	return hc.Transport.(*fakeRoundTripper)
 }
@@ -0,0 +1,59 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// defaultChildLookback bounds the search for children of a parent event whose start time is unknown
+const defaultChildLookback = 7 * 24 * time.Hour
+
+// handleOpenChildren applies the open_children policy before the parent event is closed.
+// With REFUSE, an error is returned if any child has not ended. With CASCADE, every child
+// that has not ended is closed first. PENDING children, which have yet to start, count as
+// open just as ONGOING ones do, since otherwise they would start after their parent ended
+func handleOpenChildren(ctx context.Context, client *wavefront.APIClient, policy ChildPolicy, parentID string, parent *wavefront.Event) error {
+	if policy == "" || policy == IGNORE {
+		return nil
+	}
+
+	since := parent.Start()
+	if since.IsZero() {
+		since = time.Now().Add(-defaultChildLookback)
+	}
+
+	children, err := client.FindChildEvents(ctx, parentID, since)
+	if err != nil {
+		return fmt.Errorf("could not find child events: %w", err)
+	}
+
+	var ongoing []string
+	for _, child := range children {
+		if child.RunningState != wavefront.StateEnded {
+			ongoing = append(ongoing, child.ID)
+		}
+	}
+
+	if len(ongoing) == 0 {
+		return nil
+	}
+
+	if policy == REFUSE {
+		return fmt.Errorf("%w: %s", ErrOpenChildren, strings.Join(ongoing, ", "))
+	}
+
+	for _, id := range ongoing {
+		if _, err = client.EndOngoingEvent(ctx, id, nil); err != nil {
+			return fmt.Errorf("could not close child event %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
@@ -4,14 +4,17 @@
 package out
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/drone/envsubst"
 	resource "github.com/vmware-tanzu/observability-event-resource"
@@ -37,12 +40,24 @@ func safeEnvSubst(envFunc func(string) string) func(string) string {
 }
 
 // RunCommand will either create an ongoing event (if params.action == "start")
-// or close an existing ongoing event (if params.action == "end")
-func RunCommand(stdin io.Reader, baseDir string, hc *http.Client, envFunc func(string) string) (Response, error) {
+// or close an existing ongoing event (if params.action == "end"). When closing an
+// event with params.emit_metrics set, job duration and result metrics are also sent.
+// If params.trace is set, starting an event records its trace and span IDs, and
+// closing it sends a span covering its duration. If params.action == "checkpoint",
+// a timestamped entry is appended to an ongoing event's timeline. If source.tenants is
+// set, the put is made in every tenant at once. See runTenantsCommand. The put is abandoned
+// once params.timeout elapses or ctx is done.
+//
+// If fail_on_error is false, a put that fails after its request is validated succeeds with
+// a placeholder version instead, and is abandoned after softFailTimeout unless it sets
+// params.timeout. Ending or checkpointing a placeholder does nothing, unless an outbox is
+// set. Then the failed put is queued in the outbox, the queued puts are replayed before each
+// later put, or by params.action == "replay", and ends and checkpoints of a placeholder are
+// queued behind its start. See outbox.replay
+func RunCommand(ctx context.Context, stdin io.Reader, baseDir string, hc *http.Client, envFunc func(string) string) (Response, error) {
 	var (
-		s         Request
-		err       error
-		eventJSON []byte
+		s   Request
+		err error
 	)
 
 	if err = json.NewDecoder(stdin).Decode(&s); err != nil {
@@ -57,7 +72,96 @@ func RunCommand(stdin io.Reader, baseDir string, hc *http.Client, envFunc func(s
 		return Response{}, err
 	}
 
-	client := wavefront.NewAPIClient(s.Source, hc)
+	outboxPath := s.OutboxPath()
+	if outboxPath != "" && (s.Source.ProxyAddress != "" || len(s.Source.Tenants) > 0) {
+		return Response{}, errors.New(`"outbox" cannot be used with "proxy_address" or "tenants"`)
+	}
+
+	// a queued put keeps only its event's name, annotations and tags, so it could not resubmit
+	// what these add, nor find the event an earlier attempt created
+	if outboxPath != "" && (s.Params.Parent != "" || s.Params.Trace != "" || s.Params.Idempotent || s.Params.IdempotencyKey != "" ||
+		(s.Params.OpenChildren != "" && s.Params.OpenChildren != IGNORE)) {
+		return Response{}, errors.New(`"outbox" cannot be used with "parent", "trace", "idempotent", "idempotency_key" or "open_children"`)
+	}
+
+	if s.Params.Action == REPLAY && outboxPath == "" {
+		return Response{}, errors.New(`"outbox" must be set when "action" is "replay"`)
+	}
+
+	started := time.Now()
+
+	timeout := s.Params.GetTimeout()
+	if timeout == 0 && !s.FailOnError() {
+		timeout = softFailTimeout
+	}
+
+	// the replay of the outbox shares the put's deadline, rather than adding its own
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if outboxPath == "" {
+		response, err := runPut(ctx, s, baseDir, hc, envFunc)
+		if err != nil && !s.FailOnError() {
+			return softFail(err), nil
+		}
+
+		return response, err
+	}
+
+	box := &outbox{path: outboxPath, client: wavefront.NewAPIClient(s.Source, hc)}
+	if s.Params.Action == REPLAY {
+		response, err := runReplay(ctx, box)
+		if err != nil && !s.FailOnError() {
+			return softFail(err), nil
+		}
+
+		return response, err
+	}
+
+	if s.Params.Action == END || s.Params.Action == CHECKPOINT {
+		if id, err := readEventID(baseDir, s.Params.Event); err == nil && wavefront.IsPlaceholderEventID(id) {
+			return queuePlaceholderPut(ctx, box, s, baseDir, envFunc, started, id)
+		}
+	}
+
+	// send the puts that failed earlier first, so that they stay in order
+	if replayed, pending, err := box.replay(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "%v (%d replayed, %d still queued)\n", err, replayed, pending)
+	}
+
+	response, err := runPut(ctx, s, baseDir, hc, envFunc)
+	if err == nil || s.FailOnError() {
+		return response, err
+	}
+
+	response = softFail(err)
+
+	entry, err := newOutboxEntry(s, baseDir, envFunc, started)
+	if err == nil {
+		entry.Placeholder = response.Version.ID
+		err = box.add(entry)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "the put could not be queued in the outbox: %v\n", err)
+		return response, nil
+	}
+
+	response.Metadata = append(response.Metadata, resource.Metadatum{Name: "outbox", Value: "queued"})
+	return response, nil
+}
+
+// runPut makes the put for a validated request
+func runPut(ctx context.Context, s Request, baseDir string, hc *http.Client, envFunc func(string) string) (Response, error) {
+	if s.Params.Action == END || s.Params.Action == CHECKPOINT {
+		// there is nothing to change for an event that was never recorded
+		if id, err := readEventID(baseDir, s.Params.Event); err == nil && wavefront.IsPlaceholderEventID(id) {
+			return skipPlaceholder(id, s.Params.Action), nil
+		}
+	}
 
 	annotations, err := buildAnnotationsMap(s.Params.Annotations, envFunc)
 	if err != nil {
@@ -74,56 +178,183 @@ func RunCommand(stdin io.Reader, baseDir string, hc *http.Client, envFunc func(s
 		return Response{}, err
 	}
 
-	switch s.Params.Action {
-	case CREATE:
-		eventJSON, err = client.CreateInstantEvent(name, annotations, tags)
-	case START:
-		eventJSON, err = client.StartOngoingEvent(name, annotations, tags)
-	case END:
-		idFilePath := filepath.Join(baseDir, s.Params.Event, "id")
-		idBytes, ferr := ioutil.ReadFile(idFilePath)
+	if s.Source.ProxyAddress != "" {
+		return runProxyCommand(ctx, s, baseDir, hc, envFunc, name, annotations, tags)
+	}
+
+	if s.Params.Trace == OTLP && s.Source.OTLPEndpoint == "" {
+		return Response{}, errors.New(`the "otlp_endpoint" source property must be set when "trace" is "otlp"`)
+	}
+
+	if len(s.Source.Tenants) > 0 {
+		return runTenantsCommand(ctx, s, baseDir, hc, envFunc, name, annotations, tags)
+	}
+
+	client := wavefront.NewAPIClient(s.Source, hc)
+
+	// find configuration problems before anything is changed, rather than part way through
+	if s.Source.Preflight {
+		if err = client.Preflight(ctx); err != nil {
+			return Response{}, err
+		}
+	}
+
+	return runAPICommand(ctx, client, s, baseDir, hc, envFunc, name, annotations, tags)
+}
+
+// runAPICommand carries out the put through the tenant's REST API
+func runAPICommand(ctx context.Context, client *wavefront.APIClient, s Request, baseDir string, hc *http.Client, envFunc func(string) string, name string, annotations map[string]string, tags []string) (Response, error) {
+	var (
+		err   error
+		event *wavefront.Event
+	)
+
+	// an event created by an earlier attempt is returned as it is, so that its parent, trace
+	// and span are not recorded again
+	if (s.Params.Idempotent || s.Params.IdempotencyKey != "") && (s.Params.Action == START || s.Params.Action == CREATE) {
+		key := defaultIdempotencyKey(name, envFunc)
+		if s.Params.IdempotencyKey != "" {
+			if key, err = interpolateString(s.Params.IdempotencyKey, envFunc); err != nil {
+				return Response{}, err
+			}
+		}
+
+		existing, err := findIdempotentEvent(ctx, client, s.Params.Action, name, key)
+		if err != nil {
+			return Response{}, fmt.Errorf("could not search for an existing event: %w", err)
+		}
+
+		if existing != nil {
+			metadata, err := wavefront.GetConcourseMetadata(existing, s.Source)
+			if err != nil {
+				return Response{}, fmt.Errorf("could not determine event state from response: %w", err)
+			}
+
+			return Response{
+				Version:  resource.Version{ID: existing.ID},
+				Metadata: metadata,
+			}, nil
+		}
+
+		annotations[idempotencyKeyAnnotation] = key
+	}
+
+	if s.Params.Parent != "" {
+		parentID, _, err := loadEvent(ctx, client, baseDir, s.Params.Parent)
+		if err != nil {
+			return Response{}, fmt.Errorf("could not load parent event: %w", err)
+		}
+
+		annotations[wavefront.ParentEventAnnotation] = parentID
+	}
+
+	if s.Params.Trace != "" && (s.Params.Action == START || s.Params.Action == CREATE) {
+		traceParent := s.Params.ParentEvent
+		if traceParent == "" {
+			traceParent = s.Params.Parent
+		}
+
+		if err = addTraceAnnotations(ctx, client, baseDir, traceParent, annotations); err != nil {
+			return Response{}, fmt.Errorf("could not start trace: %w", err)
+		}
+	}
+
+	switch {
+	case s.Params.Action == CREATE:
+		event, err = client.CreateInstantEvent(ctx, name, annotations, tags)
+	case s.Params.Action == START:
+		event, err = client.StartOngoingEvent(ctx, name, annotations, tags)
+	case s.Params.Action == END:
+		id, ferr := readEventID(baseDir, s.Params.Event)
 		if ferr != nil {
 			return Response{}, fmt.Errorf("could not read event ID to close: %w", ferr)
 		}
-		id := strings.TrimSpace(string(idBytes))
 
-		jsonFilePath := filepath.Join(baseDir, s.Params.Event, "event.json")
-		jsonBytes, ferr := ioutil.ReadFile(jsonFilePath)
+		started, ferr := readEvent(baseDir, s.Params.Event)
 		if ferr != nil {
 			return Response{}, fmt.Errorf("could not parse event json: %w", ferr)
 		}
 
+		if ferr = handleOpenChildren(ctx, client, s.Params.OpenChildren, id, started); ferr != nil {
+			return Response{}, ferr
+		}
+
 		// if there are no annotations here, we want to do nothing to them in the end event
 		if s.Params.Annotations == nil {
 			annotations = nil
 		}
 
-		eventJSON, err = client.EndOngoingEvent(id, jsonBytes, annotations)
+		event, err = client.EndOngoingEvent(ctx, id, annotations)
+	case s.Params.Action == CHECKPOINT:
+		id, ferr := readEventID(baseDir, s.Params.Event)
+		if ferr != nil {
+			return Response{}, fmt.Errorf("could not read event ID to checkpoint: %w", ferr)
+		}
+
+		message, ferr := interpolateString(s.Params.Checkpoint, envFunc)
+		if ferr != nil {
+			return Response{}, ferr
+		}
+
+		event, err = client.AddCheckpoint(ctx, id, message, time.Now())
 	}
 	if err != nil {
 		return Response{}, fmt.Errorf("could not complete API call: %w", err)
 	}
 
-	var event interface{}
-	if err = json.NewDecoder(bytes.NewBuffer(eventJSON)).Decode(&event); err != nil {
-		return Response{}, fmt.Errorf("could not parse response: %w", err)
+	metadata, err := wavefront.GetConcourseMetadata(event, s.Source)
+	if err != nil {
+		return Response{}, fmt.Errorf("could not determine event state from response: %w", err)
 	}
 
-	id, err := wavefront.GetEventID(event)
-	if err != nil {
-		return Response{}, fmt.Errorf("could not determine event ID from response: %w", err)
+	if s.Params.Action == END && s.Params.EmitMetrics {
+		points, err := jobMetrics(event, s.Params.JobResult, envFunc)
+		if err != nil {
+			return Response{}, fmt.Errorf("event was closed, but job metrics could not be calculated: %w", err)
+		}
+
+		if err = client.SendMetrics(ctx, points); err != nil {
+			return Response{}, fmt.Errorf("event was closed, but job metrics could not be sent: %w", err)
+		}
 	}
 
-	metadata, err := wavefront.GetConcourseMetadata(event)
-	if err != nil {
-		return Response{}, fmt.Errorf("could not determine event state from response: %w", err)
+	if s.Params.Trace != "" && (s.Params.Action == END || s.Params.Action == CREATE) {
+		if err = sendSpan(ctx, client, hc, s.Source, s.Params.Trace, event); err != nil {
+			return Response{}, fmt.Errorf("event was sent, but its span could not be: %w", err)
+		}
 	}
+
 	return Response{
-		Version:  resource.Version{ID: id},
+		Version:  resource.Version{ID: event.ID},
 		Metadata: metadata,
 	}, nil
 }
 
+// readEventID reads the id file from a previous get step's directory
+func readEventID(baseDir string, eventDir string) (string, error) {
+	idBytes, err := ioutil.ReadFile(filepath.Join(baseDir, eventDir, "id"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(idBytes)), nil
+}
+
+// readEvent reads the event.json file from a previous get step's directory
+func readEvent(baseDir string, eventDir string) (*wavefront.Event, error) {
+	eventBytes, err := ioutil.ReadFile(filepath.Join(baseDir, eventDir, "event.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var event wavefront.Event
+	if err = json.Unmarshal(eventBytes, &event); err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
 func buildAnnotationsMap(custom map[string]string, envFunc func(string) string) (map[string]string, error) {
 	annotations := make(map[string]string)
 
@@ -134,6 +365,16 @@ func buildAnnotationsMap(custom map[string]string, envFunc func(string) string)
 	annotations["severity"] = "info"
 	annotations["details"] = fmt.Sprintf("Created by Concourse observability-event-resource version %s", resource.AppVersion)
 
+	if err := overrideAnnotations(annotations, custom, envFunc); err != nil {
+		return nil, err
+	}
+
+	return annotations, nil
+}
+
+// overrideAnnotations sets each custom annotation, after interpolating its value, or removes
+// it if the value is empty
+func overrideAnnotations(annotations map[string]string, custom map[string]string, envFunc func(string) string) error {
 	var err error
 	for k, v := range custom {
 		if v == "" {
@@ -142,11 +383,11 @@ func buildAnnotationsMap(custom map[string]string, envFunc func(string) string)
 		}
 
 		if annotations[k], err = interpolateString(v, safeEnvSubst(envFunc)); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return annotations, nil
+	return nil
 }
 
 func expandTags(tags []string, envFunc func(string) string) ([]string, error) {
@@ -4,15 +4,27 @@
 package out_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/in"
 	"github.com/vmware-tanzu/observability-event-resource/internal/testutils"
 	"github.com/vmware-tanzu/observability-event-resource/out"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
 )
 
 var envMap = map[string]string{
@@ -56,37 +68,717 @@ func TestParamValidation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
+
+	p.Timeout = "soon"
+	if err = p.Validate(); err == nil {
+		t.Fatal("an expected error did not occur")
+	}
+
+	p.Timeout = ""
+	p.Outbox = "outbox/events.jsonl"
+	if err = p.Validate(); err == nil {
+		t.Fatal("an expected error did not occur")
+	}
+}
+
+func TestStartEvent(t *testing.T) {
+	stdin := strings.NewReader(startEventRequest)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+
+	resp, err := out.RunCommand(context.Background(), stdin, "", hc, os.Getenv)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if resp.Version.ID != "12345" {
+		t.Fatalf("expected output version ID to be 12345, but it was %s", resp.Version.ID)
+	}
+
+	if len(resp.Metadata) != 5 {
+		t.Fatalf("expected 5 metadata but found %d", len(resp.Metadata))
+	}
+
+	if resp.Metadata[0].Value != "My event" {
+		t.Fatalf(`expected name to be "My event" but it was %q`, resp.Metadata[0].Value)
+	}
+
+	if resp.Metadata[1].Value != "ONGOING" {
+		t.Fatalf("expected state to be ONGOING, but it was %s", resp.Metadata[1].Value)
+	}
+}
+
+func TestEndEvent(t *testing.T) {
+	stdin := strings.NewReader(endEventRequest)
+
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "id"), []byte("12345"), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "event.json"), []byte(startedEventJSON), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event/12345/close", "asdf", endEventResponse)
+
+	resp, err := out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occurred: %v", err)
+	}
+
+	if resp.Version.ID != "12345" {
+		t.Fatalf("expected output version ID to be 12345, but it was %s", resp.Version.ID)
+	}
+
+	if len(resp.Metadata) != 5 {
+		t.Fatalf("expected 5 metadata but found %d", len(resp.Metadata))
+	}
+
+	if resp.Metadata[0].Value != "My event" {
+		t.Fatalf(`expected name to be "My event" but it was %q`, resp.Metadata[0].Value)
+	}
+
+	if resp.Metadata[1].Value != "ENDED" {
+		t.Fatalf("expected state to be ENDED, but it was %s", resp.Metadata[1].Value)
+	}
+}
+
+func TestEndEventWithNewAnnotations(t *testing.T) {
+	stdin := strings.NewReader(endEventWithNewAnnotationsRequest)
+
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "id"), []byte("12345"), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "event.json"), []byte(startedEventJSON), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event/12345/close", "asdf", endEventWithNewAnnotationsResponse)
+	testutils.AddSubRequest(hc, http.MethodGet, "/api/v2/event/12345", "asdf", checkpointedEventResponse)
+	testutils.AddSubRequest(hc, http.MethodPut, "/api/v2/event/12345", "asdf", `{"response":{}}`)
+
+	_, err := out.RunCommand(context.Background(), stdin, baseDir, hc, os.Getenv)
+	if err != nil {
+		t.Fatalf("an unexpected error occurred: %v", err)
+	}
+
+	count := testutils.GetURLHitCount(hc, "/api/v2/event/12345")
+	if count != 2 {
+		t.Fatalf("expected command to read and update the event once each, but it requested it %d times", count)
+	}
+
+	sent := testutils.GetSentRequest(hc, "/api/v2/event/12345")
+	for _, expected := range []string{
+		`"severity":"FAILED"`,
+		`"foo":"bar"`,
+		`"checkpoint":"smoke tests passed"`,
+	} {
+		if !strings.Contains(sent, expected) {
+			t.Fatalf("expected the updated event to contain %s, but it was %s", expected, sent)
+		}
+	}
+
+	// an empty annotation removes it, and the job's pipeline is not set here
+	if strings.Contains(sent, `"concourse-pipeline"`) {
+		t.Fatalf("expected concourse-pipeline to be removed, but the updated event was %s", sent)
+	}
+}
+
+func TestEndEventWithMetrics(t *testing.T) {
+	stdin := strings.NewReader(endEventWithMetricsRequest)
+
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "id"), []byte("12345"), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "event.json"), []byte(startedEventJSON), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event/12345/close", "asdf", endEventWithTimesResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/report", "asdf", "")
+
+	if _, err := out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc); err != nil {
+		t.Fatalf("an unexpected error occurred: %v", err)
+	}
+
+	sent := testutils.GetSentRequest(hc, "/report")
+	expected := `concourse.job.duration 90 1604232090 source="concourse" "job"="test-job" "pipeline"="test-pipeline" "team"=""` + "\n" +
+		`concourse.job.result 0 1604232090 source="concourse" "job"="test-job" "pipeline"="test-pipeline" "result"="failed" "team"=""` + "\n"
+	if sent != expected {
+		t.Fatalf("expected metrics to be %q, but they were %q", expected, sent)
+	}
+}
+
+func TestProxyEvent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	source := fmt.Sprintf(`{"proxy_address": %q}`, listener.Addr().String())
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event", "tags": ["tag1"], "annotations": {"foo": "bar"}}}`, source))
+	resp, err := out.RunCommand(context.Background(), stdin, "", nil, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if resp.Metadata[1].Value != "ONGOING" {
+		t.Fatalf("expected state to be ONGOING, but it was %s", resp.Metadata[1].Value)
+	}
+
+	baseDir := t.TempDir()
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q}}`, source, resp.Version.ID))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), nil); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event", "annotations": {"severity": "FAILED"}}}`, source))
+	resp, err = out.RunCommand(context.Background(), stdin, baseDir, nil, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if resp.Metadata[1].Value != "ENDED" {
+		t.Fatalf("expected state to be ENDED, but it was %s", resp.Metadata[1].Value)
+	}
+
+	select {
+	case line := <-received:
+		if !strings.HasPrefix(line, "@Event ") || !strings.HasSuffix(line, "\n") {
+			t.Fatalf("expected an @Event line, but got %q", line)
+		}
+
+		for _, expected := range []string{`"My event"`, `tag="tag1"`, `"foo"="bar"`, `"severity"="FAILED"`, `"concourse-job"="test-job"`} {
+			if !strings.Contains(line, expected) {
+				t.Fatalf("expected %q to contain %s", line, expected)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the proxy never received the event")
+	}
+}
+
+func TestEventLifecycle(t *testing.T) {
+	server := fake.New(fake.WithToken("asdf"))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	source := fmt.Sprintf(`{"tenant_url": %q, "api_token": "asdf"}`, ts.URL)
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event", "annotations": {"foo": "bar"}}}`, source))
+	resp, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q}}`, source, resp.Version.ID))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), &http.Client{}); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event", "annotations": {"severity": "FAILED"}}}`, source))
+	if _, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	event, ok := server.Event(resp.Version.ID)
+	if !ok {
+		t.Fatalf("expected event %s to be stored, but it was not", resp.Version.ID)
+	}
+
+	if event.RunningState != "ENDED" || event.Annotations["foo"] != "bar" || event.Annotations["severity"] != "FAILED" {
+		t.Fatalf("expected the event to have ended with its annotations updated, but got %+v", event)
+	}
+}
+
+func TestPreflightBeforePut(t *testing.T) {
+	server := fake.New(fake.WithToken("asdf"))
+	server.InjectFailure(fake.Failure{Method: http.MethodPut, Status: http.StatusForbidden})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": {"tenant_url": %q, "api_token": "asdf", "preflight": true}, "params": {"action": "start", "event_name": "My event"}}`, ts.URL))
+	_, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+	if !errors.Is(err, wavefront.ErrPreflightFailed) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrPreflightFailed, err)
+	}
+
+	if len(server.Events()) != 0 {
+		t.Fatal("expected no event to be created when the preflight failed, but one was")
+	}
+}
+
+func TestTenantsLifecycle(t *testing.T) {
+	prod, platform := fake.New(fake.WithToken("prod")), fake.New(fake.WithToken("shared"))
+	prodServer, platformServer := httptest.NewServer(prod), httptest.NewServer(platform)
+	defer prodServer.Close()
+	defer platformServer.Close()
+
+	source := fmt.Sprintf(`{"api_token": "shared", "tenants": [
+		{"name": "prod", "url": %q, "api_token": "prod"},
+		{"name": "platform", "url": %q, "annotations": {"severity": "warn", "foo": ""}}
+	]}`, prodServer.URL, platformServer.URL)
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event", "annotations": {"foo": "bar"}}}`, source))
+	resp, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	ids, err := resp.Version.ParseTenantIDs()
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if len(ids) != 2 || ids["prod"] != resp.Version.ID {
+		t.Fatalf("expected the version to hold the ID in each tenant, with the prod ID as its ID, but got %+v", resp.Version)
+	}
+
+	platformEvent, ok := platform.Event(ids["platform"])
+	if !ok || platformEvent.Annotations["severity"] != "warn" || platformEvent.Annotations["foo"] != "" {
+		t.Fatalf("expected the platform event to have its annotations overridden, but got %+v", platformEvent)
+	}
+
+	baseDir := t.TempDir()
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q, "tenant_ids": %q}}`, source, resp.Version.ID, resp.Version.TenantIDs))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), &http.Client{}); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event"}}`, source))
+	if _, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	prodEvent, _ := prod.Event(ids["prod"])
+	platformEvent, _ = platform.Event(ids["platform"])
+	if prodEvent.RunningState != "ENDED" || platformEvent.RunningState != "ENDED" {
+		t.Fatalf("expected the event to have ended in both tenants, but got %+v and %+v", prodEvent, platformEvent)
+	}
+}
+
+func TestTenantPolicy(t *testing.T) {
+	healthy, broken := fake.New(fake.WithToken("asdf")), fake.New(fake.WithToken("asdf"))
+	broken.InjectFailure(fake.Failure{Method: http.MethodPost, Path: "/api/v2/event", Status: http.StatusForbidden})
+
+	healthyServer, brokenServer := httptest.NewServer(healthy), httptest.NewServer(broken)
+	defer healthyServer.Close()
+	defer brokenServer.Close()
+
+	cases := []struct {
+		policy  string
+		primary string
+		fails   bool
+	}{
+		{policy: "all", primary: healthyServer.URL, fails: true},
+		{policy: "any", primary: brokenServer.URL, fails: false},
+		{policy: "primary", primary: healthyServer.URL, fails: false},
+		{policy: "primary", primary: brokenServer.URL, fails: true},
+	}
+
+	for _, c := range cases {
+		secondary := healthyServer.URL
+		if c.primary == healthyServer.URL {
+			secondary = brokenServer.URL
+		}
+
+		stdin := strings.NewReader(fmt.Sprintf(`{"source": {"api_token": "asdf", "tenant_policy": %q, "tenants": [{"name": "primary", "url": %q}, {"name": "secondary", "url": %q}]},
+			"params": {"action": "create", "event_name": "My event"}}`, c.policy, c.primary, secondary))
+		resp, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+
+		if c.fails {
+			if !errors.Is(err, wavefront.ErrForbidden) {
+				t.Fatalf("policy %s with primary %s: expected to get %v as an error but got %v", c.policy, c.primary, wavefront.ErrForbidden, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("policy %s with primary %s: an unexpected error occured: %v", c.policy, c.primary, err)
+		}
+
+		if _, ok := healthy.Event(resp.Version.ID); !ok {
+			t.Fatalf("policy %s with primary %s: expected the version to be the event in the healthy tenant, but got %+v", c.policy, c.primary, resp.Version)
+		}
+
+		var failed string
+		for _, m := range resp.Metadata {
+			if m.Name == "failed_tenants" {
+				failed = m.Value
+			}
+		}
+
+		if !strings.Contains(failed, "forbidden") {
+			t.Fatalf("policy %s with primary %s: expected the failed tenant in the metadata, but got %+v", c.policy, c.primary, resp.Metadata)
+		}
+	}
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": {"api_token": "asdf", "tenant_policy": "most", "tenants": [{"name": "primary", "url": %q}]},
+		"params": {"action": "create", "event_name": "My event"}}`, healthyServer.URL))
+	if _, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc); !errors.Is(err, resource.ErrInvalidTenants) {
+		t.Fatalf("expected to get %v as an error but got %v", resource.ErrInvalidTenants, err)
+	}
+}
+
+func TestTenantPolicySkipsMissingEvents(t *testing.T) {
+	healthy, broken := fake.New(fake.WithToken("asdf")), fake.New(fake.WithToken("asdf"))
+	broken.InjectFailure(fake.Failure{Method: http.MethodPost, Path: "/api/v2/event", Status: http.StatusForbidden})
+
+	healthyServer, brokenServer := httptest.NewServer(healthy), httptest.NewServer(broken)
+	defer healthyServer.Close()
+	defer brokenServer.Close()
+
+	source := fmt.Sprintf(`{"api_token": "asdf", "tenant_policy": "primary", "tenants": [{"name": "primary", "url": %q}, {"name": "secondary", "url": %q}]}`,
+		healthyServer.URL, brokenServer.URL)
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event"}}`, source))
+	resp, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	baseDir := t.TempDir()
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q, "tenant_ids": %q}}`, source, resp.Version.ID, resp.Version.TenantIDs))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), &http.Client{}); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	requests := len(broken.Requests())
+
+	// the secondary tenant has no event to end, which is not a failure
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event"}}`, source))
+	if resp, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	for _, m := range resp.Metadata {
+		if m.Name == "failed_tenants" {
+			t.Fatalf("expected no tenant to fail, but got %s", m.Value)
+		}
+	}
+
+	if event, _ := healthy.Event(resp.Version.ID); event.RunningState != "ENDED" {
+		t.Fatalf("expected the event to have ended in the primary tenant, but got %+v", event)
+	}
+
+	if len(broken.Requests()) != requests {
+		t.Fatalf("expected the secondary tenant not to be contacted, but the requests were %v", broken.Requests()[requests:])
+	}
+
+	// the primary tenant cannot be skipped, even if every other tenant fails
+	swapped := fmt.Sprintf(`{"api_token": "asdf", "tenant_policy": "any", "tenants": [{"name": "primary", "url": %q}, {"name": "secondary", "url": %q}]}`,
+		brokenServer.URL, healthyServer.URL)
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event"}}`, swapped))
+	if resp, err = out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err = os.RemoveAll(path.Join(baseDir, "some-event")); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q, "tenant_ids": %q}}`, swapped, resp.Version.ID, resp.Version.TenantIDs))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), &http.Client{}); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	healthy.InjectFailure(fake.Failure{Path: "/api/v2/event/", Status: http.StatusForbidden})
+
+	swapped = strings.Replace(swapped, `"any"`, `"primary"`, 1)
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event"}}`, swapped))
+	if _, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); !errors.Is(err, out.ErrMissingTenantEvent) {
+		t.Fatalf("expected to get %v as an error but got %v", out.ErrMissingTenantEvent, err)
+	}
+}
+
+func TestSoftFail(t *testing.T) {
+	server := fake.New(fake.WithToken("asdf"))
+	server.InjectFailure(fake.Failure{Status: http.StatusServiceUnavailable})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	source := fmt.Sprintf(`{"tenant_url": %q, "api_token": "asdf", "fail_on_error": false, "retry": {"max_elapsed_time": "1ms"}}`, ts.URL)
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event", "fail_on_error": true}}`, source))
+	if _, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc); !errors.Is(err, wavefront.ErrServerError) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrServerError, err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event"}}`, source))
+	resp, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if !wavefront.IsPlaceholderEventID(resp.Version.ID) {
+		t.Fatalf("expected a placeholder version, but got %+v", resp.Version)
+	}
+
+	if len(resp.Metadata) != 1 || resp.Metadata[0].Name != "error" || !strings.Contains(resp.Metadata[0].Value, "503") {
+		t.Fatalf("expected the error to be recorded in the metadata, but got %+v", resp.Metadata)
+	}
+
+	// neither the get after the put, nor the end, may contact the tenant
+	requests := len(server.Requests())
+
+	baseDir := t.TempDir()
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q}}`, source, resp.Version.ID))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), &http.Client{}); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event", "fail_on_error": true}}`, source))
+	endResp, err := out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if endResp.Version.ID != resp.Version.ID || len(endResp.Metadata) != 1 || endResp.Metadata[0].Name != "skipped" {
+		t.Fatalf("expected the end to be skipped, but got %+v", endResp)
+	}
+
+	if len(server.Requests()) != requests {
+		t.Fatalf("expected no requests for a placeholder, but got %v", server.Requests()[requests:])
+	}
+}
+
+// deadlineRoundTripper records whether each request had a deadline, and fails it
+type deadlineRoundTripper struct {
+	deadlines []time.Duration
+}
+
+func (d *deadlineRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		d.deadlines = append(d.deadlines, 0)
+	} else {
+		d.deadlines = append(d.deadlines, time.Until(deadline))
+	}
+
+	return nil, errors.New("connection refused")
+}
+
+func TestSoftFailDeadline(t *testing.T) {
+	rt := &deadlineRoundTripper{}
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo.com", "api_token": "asdf", "fail_on_error": false, "request_timeout": "0", "retry": {"max_elapsed_time": "1ms"}}, "params": {"action": "start", "event_name": "My event"}}`)
+	if _, err := out.RunCommand(context.Background(), stdin, "", &http.Client{Transport: rt}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if len(rt.deadlines) == 0 {
+		t.Fatal("expected the put to be attempted")
+	}
+
+	// a put that may fail gives up well before the retry policy would
+	for _, d := range rt.deadlines {
+		if d <= 0 || d > time.Minute {
+			t.Fatalf("expected each request to have a deadline within a minute, but got %v", rt.deadlines)
+		}
+	}
 }
 
-func TestStartEvent(t *testing.T) {
-	stdin := strings.NewReader(startEventRequest)
+func TestOutbox(t *testing.T) {
+	// had the replayed puts not kept their times, the server would give them its own
+	later := time.Now().Add(time.Hour)
+	server := fake.New(fake.WithToken("asdf"), fake.WithClock(func() time.Time { return later }))
+	server.InjectFailure(fake.Failure{Status: http.StatusServiceUnavailable})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// the outbox is kept apart from the build directory, which does not outlive the put
+	outboxPath := filepath.Join(t.TempDir(), "outbox", "events.jsonl")
+	source := fmt.Sprintf(`{"tenant_url": %q, "api_token": "asdf", "fail_on_error": false, "outbox": %q, "retry": {"max_elapsed_time": "1ms"}}`, ts.URL, outboxPath)
+	baseDir := t.TempDir()
 
-	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "start", "event_name": "My event", "annotations": {"foo": "bar"}}}`, source))
+	resp, err := out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc)
+	if err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if !wavefront.IsPlaceholderEventID(resp.Version.ID) || resp.Metadata[len(resp.Metadata)-1].Value != "queued" {
+		t.Fatalf("expected the start to be queued behind a placeholder, but got %+v", resp)
+	}
+
+	if err = os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "version": {"id": %q}}`, source, resp.Version.ID))
+	if _, err = in.RunCommand(context.Background(), stdin, path.Join(baseDir, "some-event"), &http.Client{}); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "checkpoint", "event": "some-event", "checkpoint": "deployed"}}`, source))
+	if resp, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if resp.Metadata[0].Value != "0 replayed, 2 pending" {
+		t.Fatalf("expected the checkpoint to be queued behind the start, but got %+v", resp.Metadata)
+	}
+
+	server.ClearFailures()
+
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "replay"}}`, source))
+	if resp, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if resp.Metadata[0].Value != "2" || resp.Metadata[1].Value != "0" {
+		t.Fatalf("expected both queued puts to be replayed, but got %+v", resp.Metadata)
+	}
+
+	// the placeholder now maps to the event the replayed start created
+	requests := len(server.Requests())
+	stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": {"action": "end", "event": "some-event"}}`, source))
+	if resp, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if resp.Metadata[0].Value != "1 replayed, 0 pending" {
+		t.Fatalf("expected the end to be sent to the started event, but got %+v", resp.Metadata)
+	}
+
+	// the replayed end closes the event, then moves its end time back to when the put was made
+	id := server.Events()[0].ID
+	replayed := server.Requests()[requests:]
+	expected := []string{"POST /api/v2/event/" + id + "/close", "PUT /api/v2/event/" + id}
+	if !reflect.DeepEqual(replayed, expected) {
+		t.Fatalf("expected the replayed end to make the requests %v, but it made %v", expected, replayed)
+	}
+
+	events := server.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected one event to be created, but got %+v", events)
+	}
+
+	event := events[0]
+	if event.RunningState != "ENDED" || event.Annotations["foo"] != "bar" || len(event.Timeline()) != 1 || !event.Start().Before(later.Add(-time.Minute)) || !event.End().Before(later.Add(-time.Minute)) {
+		t.Fatalf("expected the event to have been started, checkpointed and ended at the original times, but got %+v", event)
+	}
 
-	resp, err := out.RunCommand(stdin, "", hc, os.Getenv)
+	entries, err := ioutil.ReadFile(outboxPath)
 	if err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if resp.Version.ID != "12345" {
-		t.Fatalf("expected output version ID to be 12345, but it was %s", resp.Version.ID)
+	if len(entries) != 0 {
+		t.Fatalf("expected the outbox to be empty once the event ended, but it has %s", entries)
 	}
 
-	if len(resp.Metadata) != 2 {
-		t.Fatalf("expected 2 metadata but found %d", len(resp.Metadata))
+	// a resubmitted put could not keep what these add to the event
+	for _, params := range []string{
+		`{"action": "start", "event_name": "My event", "parent": "12345"}`,
+		`{"action": "start", "event_name": "My event", "trace": "wavefront"}`,
+		`{"action": "start", "event_name": "My event", "idempotent": true}`,
+		`{"action": "start", "event_name": "My event", "idempotency_key": "deploy"}`,
+		`{"action": "end", "event": "some-event", "open_children": "cascade"}`,
+	} {
+		stdin = strings.NewReader(fmt.Sprintf(`{"source": %s, "params": %s}`, source, params))
+		if _, err = out.RunCommand(context.Background(), stdin, baseDir, &http.Client{}, envFunc); err == nil || !strings.Contains(err.Error(), `"outbox" cannot be used`) {
+			t.Fatalf("expected %s to be rejected with an outbox, but got %v", params, err)
+		}
 	}
+}
 
-	if resp.Metadata[0].Value != "My event" {
-		t.Fatalf(`expected name to be "My event" but it was %q`, resp.Metadata[0].Value)
+func TestStartTracedEvent(t *testing.T) {
+	stdin := strings.NewReader(startTracedEventRequest)
+
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, "parent-event"), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if resp.Metadata[1].Value != "ONGOING" {
-		t.Fatalf("expected state to be ONGOING, but it was %s", resp.Metadata[1].Value)
+	if err := ioutil.WriteFile(path.Join(baseDir, "parent-event", "id"), []byte("1"), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, "parent-event", "event.json"), []byte(parentEventJSON), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+
+	if _, err := out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	requestBody := testutils.GetSentRequest(hc, "/api/v2/event")
+	for _, expected := range []string{
+		`"trace-id":"0af7651916cd43dd8448eb211c80319c"`,
+		`"parent-span-id":"b7ad6b7169203331"`,
+		`"span-id":"`,
+	} {
+		if !strings.Contains(requestBody, expected) {
+			t.Fatalf("expected the request to contain %s, but it was %s", expected, requestBody)
+		}
 	}
 }
 
-func TestEndEvent(t *testing.T) {
-	stdin := strings.NewReader(endEventRequest)
+func TestEndTracedEvent(t *testing.T) {
+	stdin := strings.NewReader(endTracedEventRequest)
 
 	baseDir := t.TempDir()
 	if err := os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
@@ -97,63 +789,192 @@ func TestEndEvent(t *testing.T) {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "event.json"), []byte(startEventResponse), 0666); err != nil {
+	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "event.json"), []byte(startedEventJSON), 0666); err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event/12345/close", "asdf", endEventResponse)
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event/12345/close", "asdf", endTracedEventResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/v1/traces", "", "{}")
 
-	resp, err := out.RunCommand(stdin, baseDir, hc, envFunc)
-	if err != nil {
-		t.Fatalf("an unexpected error occurred: %v", err)
+	if _, err := out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if resp.Version.ID != "12345" {
-		t.Fatalf("expected output version ID to be 12345, but it was %s", resp.Version.ID)
+	requestBody := testutils.GetSentRequest(hc, "/v1/traces")
+	for _, expected := range []string{
+		`"traceId":"0af7651916cd43dd8448eb211c80319c"`,
+		`"spanId":"00f067aa0ba902b7"`,
+		`"parentSpanId":"b7ad6b7169203331"`,
+		`"startTimeUnixNano":"1604232000000000000"`,
+		`"endTimeUnixNano":"1604232090000000000"`,
+		`"status":{"code":1}`,
+	} {
+		if !strings.Contains(requestBody, expected) {
+			t.Fatalf("expected the span to contain %s, but it was %s", expected, requestBody)
+		}
 	}
+}
+
+func TestStartChildEvent(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo.com", "api_token": "asdf"}, "params": {"action": "start", "event_name": "Deploy", "parent": "parent-event"}}`)
 
-	if len(resp.Metadata) != 2 {
-		t.Fatalf("expected 2 metadata but found %d", len(resp.Metadata))
+	baseDir := writeEventDir(t, "parent-event", "1", parentEventJSON)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+
+	if _, err := out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if resp.Metadata[0].Value != "My event" {
-		t.Fatalf(`expected name to be "My event" but it was %q`, resp.Metadata[0].Value)
+	requestBody := testutils.GetSentRequest(hc, "/api/v2/event")
+	if !strings.Contains(requestBody, `"parent-event-id":"1"`) {
+		t.Fatalf("expected the request to record the parent event ID, but it was %s", requestBody)
+	}
+}
+
+func TestEndEventWithOpenChildren(t *testing.T) {
+	baseDir := writeEventDir(t, "parent-event", "1", parentEventJSON)
+
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo.com", "api_token": "asdf"}, "params": {"action": "end", "event": "parent-event", "open_children": "refuse"}}`)
+
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/search/event", "asdf", childEventsResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event/1/close", "asdf", endEventResponse)
+
+	_, err := out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc)
+	if !errors.Is(err, out.ErrOpenChildren) {
+		t.Fatalf("expected to get %v as an error but got %v", out.ErrOpenChildren, err)
 	}
 
-	if resp.Metadata[1].Value != "ENDED" {
-		t.Fatalf("expected state to be ENDED, but it was %s", resp.Metadata[1].Value)
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/1/close"); count != 0 {
+		t.Fatalf("expected the parent event not to be closed, but it was closed %d times", count)
+	}
+
+	search := testutils.GetSentRequest(hc, "/api/v2/search/event")
+	if !strings.Contains(search, `{"key":"annotations.parent-event-id","value":"1","matchingMethod":"EXACT"}`) {
+		t.Fatalf("expected the children to be searched for by their parent annotation, but the search was %s", search)
+	}
+
+	stdin = strings.NewReader(`{"source": {"tenant_url": "https://foo.com", "api_token": "asdf"}, "params": {"action": "end", "event": "parent-event", "open_children": "cascade"}}`)
+
+	hc = testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/search/event", "asdf", childEventsResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event/1/close", "asdf", endEventResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event/3/close", "asdf", endEventResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event/4/close", "asdf", endEventResponse)
+
+	if _, err = out.RunCommand(context.Background(), stdin, baseDir, hc, envFunc); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/3/close"); count != 1 {
+		t.Fatalf("expected the ongoing child event to be closed once, but it was closed %d times", count)
+	}
+
+	// a child that has yet to start would otherwise start after its parent ended
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/4/close"); count != 1 {
+		t.Fatalf("expected the pending child event to be closed once, but it was closed %d times", count)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/2/close"); count != 0 {
+		t.Fatalf("expected the ended child event not to be closed, but it was closed %d times", count)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event/1/close"); count != 1 {
+		t.Fatalf("expected the parent event to be closed once, but it was closed %d times", count)
 	}
 }
 
-func TestEndEventWithNewAnnotations(t *testing.T) {
-	stdin := strings.NewReader(endEventWithNewAnnotationsRequest)
+func TestIdempotentStart(t *testing.T) {
+	request := `{"source": {"tenant_url": "https://foo.com", "api_token": "asdf"}, "params": {"action": "start", "event_name": "My event", "idempotency_key": "%s"}}`
 
-	baseDir := t.TempDir()
-	if err := os.MkdirAll(path.Join(baseDir, "some-event"), 0777); err != nil {
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/search/event", "asdf", idempotentSearchResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+
+	resp, err := out.RunCommand(context.Background(), strings.NewReader(fmt.Sprintf(request, "build-1")), "", hc, envFunc)
+	if err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "id"), []byte("12345"), 0666); err != nil {
+	if resp.Version.ID != "existing" {
+		t.Fatalf("expected the existing event to be returned, but got %s", resp.Version.ID)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event"); count != 0 {
+		t.Fatalf("expected no event to be created, but %d were", count)
+	}
+
+	hc = testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/search/event", "asdf", idempotentSearchResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+
+	if resp, err = out.RunCommand(context.Background(), strings.NewReader(fmt.Sprintf(request, "build-2")), "", hc, envFunc); err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	if err := ioutil.WriteFile(path.Join(baseDir, "some-event", "event.json"), []byte(startEventResponse), 0666); err != nil {
+	if resp.Version.ID != "12345" {
+		t.Fatalf("expected a new event to be created, but got %s", resp.Version.ID)
+	}
+
+	if requestBody := testutils.GetSentRequest(hc, "/api/v2/event"); !strings.Contains(requestBody, `"idempotency-key":"build-2"`) {
+		t.Fatalf("expected the new event to record its idempotency key, but the request was %s", requestBody)
+	}
+
+	// an event that has ended is not returned for a repeated start
+	hc = testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/search/event", "asdf", idempotentSearchResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+
+	if resp, err = out.RunCommand(context.Background(), strings.NewReader(fmt.Sprintf(request, "build-3")), "", hc, envFunc); err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event/12345/close", "asdf", endEventWithNewAnnotationsResponse)
-	testutils.AddSubRequest(hc, http.MethodPut, "/api/v2/event/12345", "asdf", `{"response":{}}`)
+	if resp.Version.ID != "12345" {
+		t.Fatalf("expected a new event to be started in place of the ended one, but got %s", resp.Version.ID)
+	}
+}
+
+func TestIdempotentTracedCreate(t *testing.T) {
+	stdin := strings.NewReader(`{"source": {"tenant_url": "https://foo.com", "api_token": "asdf"}, "params": {"action": "create", "event_name": "My event", "idempotency_key": "build-1", "trace": "wavefront"}}`)
 
-	_, err := out.RunCommand(stdin, baseDir, hc, os.Getenv)
+	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/search/event", "asdf", idempotentSearchResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/api/v2/event", "asdf", startEventResponse)
+	testutils.AddSubRequest(hc, http.MethodPost, "/report", "asdf", "")
+
+	resp, err := out.RunCommand(context.Background(), stdin, "", hc, envFunc)
 	if err != nil {
-		t.Fatalf("an unexpected error occurred: %v", err)
+		t.Fatalf("an unexpected error occured: %v", err)
 	}
 
-	count := testutils.GetURLHitCount(hc, "/api/v2/event/12345")
-	if count != 1 {
-		t.Fatalf("expected command to update the event 1 time, but it updated it %d times", count)
+	if resp.Version.ID != "existing" {
+		t.Fatalf("expected the existing event to be returned, but got %s", resp.Version.ID)
+	}
+
+	// the first attempt already sent the event's span
+	if count := testutils.GetURLHitCount(hc, "/report"); count != 0 {
+		t.Fatalf("expected no span to be sent, but %d were", count)
+	}
+
+	if count := testutils.GetURLHitCount(hc, "/api/v2/event"); count != 0 {
+		t.Fatalf("expected no event to be created, but %d were", count)
+	}
+}
+
+func TestPutTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices the client going away once the body has been read
+		ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	stdin := strings.NewReader(fmt.Sprintf(`{"source": {"tenant_url": "%s", "api_token": "asdf"}, "params": {"action": "start", "event_name": "My event", "timeout": "100ms"}}`, server.URL))
+
+	start := time.Now()
+	_, err := out.RunCommand(context.Background(), stdin, "", &http.Client{}, envFunc)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to get %v as an error but got %v", context.DeadlineExceeded, err)
 	}
 
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the put to be abandoned after its timeout, but it took %s", elapsed)
+	}
 }
 
 func TestVariablizedEvent(t *testing.T) {
@@ -161,7 +982,7 @@ func TestVariablizedEvent(t *testing.T) {
 
 	hc := testutils.GetFakeHTTPClient(http.MethodPost, "/api/v2/event", "asdf", variablizedEventResponse)
 
-	resp, err := out.RunCommand(stdin, "", hc, envFunc)
+	resp, err := out.RunCommand(context.Background(), stdin, "", hc, envFunc)
 	if err != nil {
 		t.Fatalf("an unexpected error occured: %v", err)
 	}
@@ -170,8 +991,8 @@ func TestVariablizedEvent(t *testing.T) {
 		t.Fatalf("expected output version ID to be 12345, but it was %s", resp.Version.ID)
 	}
 
-	if len(resp.Metadata) != 2 {
-		t.Fatalf("expected 2 metadata but found %d", len(resp.Metadata))
+	if len(resp.Metadata) != 5 {
+		t.Fatalf("expected 5 metadata but found %d", len(resp.Metadata))
 	}
 
 	if resp.Metadata[0].Value != "My event in test-pipeline" {
@@ -192,6 +1013,23 @@ func TestVariablizedEvent(t *testing.T) {
 	}
 }
 
+func writeEventDir(t *testing.T, dir string, id string, eventJSON string) string {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, dir), 0777); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, dir, "id"), []byte(id), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(baseDir, dir, "event.json"), []byte(eventJSON), 0666); err != nil {
+		t.Fatalf("an unexpected error occured: %v", err)
+	}
+
+	return baseDir
+}
+
 func envFunc(str string) string {
 	if s, ok := envMap[str]; ok {
 		return s
@@ -240,6 +1078,46 @@ const (
 	}
 	`
 
+	startedEventJSON = `
+	{
+		"id": "12345",
+		"name": "My event",
+		"runningState": "ONGOING",
+		"annotations": {
+			"foo": "bar",
+			"concourse-job": "",
+			"concourse-team": "",
+			"concourse-pipeline": "test-pipeline",
+			"concourse-build-url": "/builds/",
+			"severity": "info",
+			"details": "Created by concourse observability-event-resource version 0.0.0-dev"
+		},
+		"tags": ["tag1", "tag2"]
+	}
+	`
+
+	checkpointedEventResponse = `
+	{
+		"status": {},
+		"response": {
+			"id": "12345",
+			"name": "My event",
+			"runningState": "ONGOING",
+			"annotations": {
+				"foo": "bar",
+				"concourse-job": "",
+				"concourse-team": "",
+				"concourse-pipeline": "test-pipeline",
+				"concourse-build-url": "/builds/",
+				"severity": "info",
+				"checkpoint": "smoke tests passed",
+				"details": "Created by concourse observability-event-resource version 0.0.0-dev"
+			},
+			"tags": ["tag1", "tag2"]
+		}
+	}
+	`
+
 	endEventRequest = `
 	{
 		"source": {
@@ -273,6 +1151,129 @@ const (
 	}
 	`
 
+	endEventWithMetricsRequest = `
+	{
+		"source": {
+			"tenant_url": "https://foo.com",
+			"api_token": "asdf"
+		},
+		"params": {
+			"action": "end",
+			"event": "some-event",
+			"emit_metrics": true
+		}
+	}
+	`
+
+	endEventWithTimesResponse = `
+	{
+		"status": {},
+		"response": {
+			"id": "12345",
+			"name": "My event",
+			"runningState": "ENDED",
+			"startTime": 1604232000000,
+			"endTime": 1604232090000,
+			"annotations": {
+				"concourse-job": "test-job",
+				"concourse-team": "",
+				"concourse-pipeline": "test-pipeline",
+				"severity": "failed"
+			}
+		}
+	}
+	`
+
+	startTracedEventRequest = `
+	{
+		"source": {
+			"tenant_url": "https://foo.com",
+			"api_token": "asdf"
+		},
+		"params": {
+			"action": "start",
+			"event_name": "Deploy to staging",
+			"trace": "wavefront",
+			"parent_event": "parent-event"
+		}
+	}
+	`
+
+	parentEventJSON = `
+	{
+		"id": "1",
+		"name": "Release v1.2",
+		"runningState": "ONGOING",
+		"annotations": {
+			"trace-id": "0af7651916cd43dd8448eb211c80319c",
+			"span-id": "b7ad6b7169203331"
+		}
+	}
+	`
+
+	childEventsResponse = `
+	{
+		"status": {},
+		"response": {
+			"moreItems": false,
+			"items": [
+				{"id": "2", "name": "Deploy to staging", "runningState": "ENDED", "annotations": {"parent-event-id": "1"}},
+				{"id": "3", "name": "Deploy to production", "runningState": "ONGOING", "annotations": {"parent-event-id": "1"}},
+				{"id": "4", "name": "Smoke tests", "runningState": "PENDING", "annotations": {"parent-event-id": "1"}}
+			]
+		}
+	}
+	`
+
+	idempotentSearchResponse = `
+	{
+		"status": {},
+		"response": {
+			"moreItems": false,
+			"items": [
+				{"id": "other", "name": "My event", "runningState": "ONGOING", "annotations": {"idempotency-key": "build-0"}},
+				{"id": "existing", "name": "My event", "runningState": "ONGOING", "annotations": {"idempotency-key": "build-1"}},
+				{"id": "ended", "name": "My event", "runningState": "ENDED", "annotations": {"idempotency-key": "build-3"}}
+			]
+		}
+	}
+	`
+
+	endTracedEventRequest = `
+	{
+		"source": {
+			"tenant_url": "https://foo.com",
+			"api_token": "asdf",
+			"otlp_endpoint": "http://collector:4318/v1/traces"
+		},
+		"params": {
+			"action": "end",
+			"event": "some-event",
+			"trace": "otlp"
+		}
+	}
+	`
+
+	endTracedEventResponse = `
+	{
+		"status": {},
+		"response": {
+			"id": "12345",
+			"name": "Deploy to staging",
+			"runningState": "ENDED",
+			"startTime": 1604232000000,
+			"endTime": 1604232090000,
+			"annotations": {
+				"concourse-pipeline": "test-pipeline",
+				"severity": "info",
+				"trace-id": "0af7651916cd43dd8448eb211c80319c",
+				"span-id": "00f067aa0ba902b7",
+				"parent-span-id": "b7ad6b7169203331"
+			}
+		}
+	}
+	`
+
 	variablizedEventRequest = `
 	{
 		"source": {
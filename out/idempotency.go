@@ -0,0 +1,60 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// idempotencyKeyAnnotation records the key an event was created with
+const idempotencyKeyAnnotation = "idempotency-key"
+
+// idempotencyLookback bounds how far back to search for an event created with the same key.
+// A put retried later than this creates a new event
+const idempotencyLookback = 24 * time.Hour
+
+// defaultIdempotencyKey derives a key from the build and the event name, so that a retried
+// put in the same build finds the event created by the first attempt
+func defaultIdempotencyKey(name string, envFunc func(string) string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/builds/%s\n%s\n%s",
+		envFunc("ATC_EXTERNAL_URL"), envFunc("BUILD_ID"), envFunc("BUILD_JOB_NAME"), name)))
+
+	return hex.EncodeToString(sum[:16])
+}
+
+// findIdempotentEvent searches for an event with the given name that was created with the
+// given key, and returns it, or nil if there is none. For a start, only an event that is
+// still ONGOING matches, since one that has ended cannot stand for the event being started
+func findIdempotentEvent(ctx context.Context, client *wavefront.APIClient, action EventAction, name string, key string) (*wavefront.Event, error) {
+	now := time.Now()
+
+	events, err := client.SearchEvents(ctx, wavefront.EventSearch{
+		Query: []wavefront.SearchCondition{
+			{Key: "name", Value: name, MatchingMethod: "EXACT"},
+		},
+		Earliest: now.Add(-idempotencyLookback),
+		Latest:   now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matches := wavefront.FilterEvents(events, wavefront.EventFilter{
+		Annotations: map[string]string{idempotencyKeyAnnotation: key},
+	})
+
+	for i := range matches {
+		if action != START || matches[i].RunningState == wavefront.StateOngoing {
+			return &matches[i], nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package out
+
+import "os"
+
+// lockFile does not lock on Windows, where the resource does not run as part of Concourse
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
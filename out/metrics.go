@@ -0,0 +1,73 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// jobMetrics builds the concourse.job.duration and concourse.job.result points for an
+// event that has just been closed. If result is not set, it is derived from the event's
+// severity annotation
+func jobMetrics(event *wavefront.Event, result JobResult, envFunc func(string) string) ([]wavefront.Point, error) {
+	start, end := event.Start(), event.End()
+	if start.IsZero() {
+		return nil, fmt.Errorf("event has no start time")
+	}
+
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	annotations := event.Annotations
+
+	if result == "" {
+		result = SUCCEEDED
+		if wavefront.IsFailureSeverity(annotations["severity"], nil) {
+			result = FAILED
+		}
+	}
+
+	tags := map[string]string{
+		"team":     annotationOrEnv(annotations, "concourse-team", envFunc, "BUILD_TEAM_NAME"),
+		"pipeline": annotationOrEnv(annotations, "concourse-pipeline", envFunc, "BUILD_PIPELINE_NAME"),
+		"job":      annotationOrEnv(annotations, "concourse-job", envFunc, "BUILD_JOB_NAME"),
+	}
+
+	resultValue := 0.0
+	if result == SUCCEEDED {
+		resultValue = 1
+	}
+
+	resultTags := map[string]string{"result": string(result)}
+	for k, v := range tags {
+		resultTags[k] = v
+	}
+
+	return []wavefront.Point{
+		{
+			Metric:    "concourse.job.duration",
+			Value:     end.Sub(start).Seconds(),
+			Timestamp: end,
+			Tags:      tags,
+		},
+		{
+			Metric:    "concourse.job.result",
+			Value:     resultValue,
+			Timestamp: end,
+			Tags:      resultTags,
+		},
+	}, nil
+}
+
+func annotationOrEnv(annotations map[string]string, key string, envFunc func(string) string, envVar string) string {
+	if v := annotations[key]; v != "" {
+		return v
+	}
+
+	return envFunc(envVar)
+}
@@ -0,0 +1,341 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// outboxEntry is a line of the outbox. It is either a put that failed, to be replayed, or,
+// if it has no action, the ID of the event created when a queued start was replayed
+type outboxEntry struct {
+	Action EventAction `json:"action,omitempty"`
+	// Placeholder is the version the failed put returned, or the one that is mapped to EventID
+	Placeholder string `json:"placeholder"`
+	// EventID is the event to end or checkpoint, which may be a placeholder, or the event
+	// Placeholder maps to
+	EventID string `json:"event_id,omitempty"`
+	// Time is when the put was made, in epoch milliseconds, which the replayed event keeps
+	Time        int64             `json:"time,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Checkpoint  string            `json:"checkpoint,omitempty"`
+}
+
+// outbox is a file of JSON lines holding the puts that failed, in the order they were made
+type outbox struct {
+	path   string
+	client *wavefront.APIClient
+}
+
+// newOutboxEntry records the put, as it was made at the given time, so that it can be queued
+func newOutboxEntry(s Request, baseDir string, envFunc func(string) string, at time.Time) (outboxEntry, error) {
+	var err error
+	entry := outboxEntry{Action: s.Params.Action, Time: at.UnixNano() / int64(time.Millisecond)}
+
+	if entry.Name, err = interpolateString(s.Params.Name, envFunc); err != nil {
+		return outboxEntry{}, err
+	}
+
+	if entry.Tags, err = expandTags(s.Params.Tags, envFunc); err != nil {
+		return outboxEntry{}, err
+	}
+
+	// as when the put is made, an end without annotations leaves them as they are
+	if s.Params.Action != END || s.Params.Annotations != nil {
+		if entry.Annotations, err = buildAnnotationsMap(s.Params.Annotations, envFunc); err != nil {
+			return outboxEntry{}, err
+		}
+	}
+
+	if s.Params.Action == END || s.Params.Action == CHECKPOINT {
+		if entry.EventID, err = readEventID(baseDir, s.Params.Event); err != nil {
+			return outboxEntry{}, fmt.Errorf("could not read event ID: %w", err)
+		}
+	}
+
+	if s.Params.Action == CHECKPOINT {
+		if entry.Checkpoint, err = interpolateString(s.Params.Checkpoint, envFunc); err != nil {
+			return outboxEntry{}, err
+		}
+	}
+
+	return entry, nil
+}
+
+// runReplay replays the outbox. Since no single event results, the version is a placeholder
+func runReplay(ctx context.Context, box *outbox) (Response, error) {
+	replayed, pending, err := box.replay(ctx)
+	if err != nil {
+		return Response{}, fmt.Errorf("%w (%d replayed, %d still queued)", err, replayed, pending)
+	}
+
+	return Response{
+		Version: resource.Version{ID: wavefront.NewPlaceholderEventID(time.Now())},
+		Metadata: resource.Metadata{
+			{Name: "replayed", Value: strconv.Itoa(replayed)},
+			{Name: "pending", Value: strconv.Itoa(pending)},
+		},
+	}, nil
+}
+
+// queuePlaceholderPut queues the end or checkpoint of a placeholder behind the start that
+// the placeholder stands for, then replays the outbox, which sends it to the started event
+// if the start has been replayed
+func queuePlaceholderPut(ctx context.Context, box *outbox, s Request, baseDir string, envFunc func(string) string, at time.Time, id string) (Response, error) {
+	entry, err := newOutboxEntry(s, baseDir, envFunc, at)
+	if err != nil {
+		return Response{}, fmt.Errorf("could not queue %s: %w", s.Params.Action, err)
+	}
+
+	entry.Placeholder = id
+	if err = box.add(entry); err != nil {
+		return Response{}, fmt.Errorf("could not queue %s: %w", s.Params.Action, err)
+	}
+
+	replayed, pending, err := box.replay(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v (%d replayed, %d still queued)\n", err, replayed, pending)
+	}
+
+	return Response{
+		Version: resource.Version{ID: id},
+		Metadata: resource.Metadata{
+			{Name: "outbox", Value: fmt.Sprintf("%d replayed, %d pending", replayed, pending)},
+		},
+	}, nil
+}
+
+// add appends the entry to the outbox, creating it if needed
+func (o *outbox) add(entry outboxEntry) error {
+	unlock, err := lockOutbox(o.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open outbox: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write to outbox: %w", err)
+	}
+
+	return f.Sync()
+}
+
+// replay resubmits the queued puts in order, with their original times, and returns how
+// many were sent and how many are still queued. It stops at the first put that fails, unless
+// the API rejected it outright, in which case the put is dropped, since it can never succeed.
+// Ends and checkpoints of placeholders are sent to the events their starts created
+func (o *outbox) replay(ctx context.Context) (int, int, error) {
+	unlock, err := lockOutbox(o.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	entries, err := o.read()
+	if err != nil || len(entries) == 0 {
+		return 0, 0, err
+	}
+
+	var (
+		placeholders []string
+		pending      []outboxEntry
+		replayed     int
+		replayErr    error
+	)
+
+	events := map[string]string{}
+	for _, entry := range entries {
+		if entry.Action == "" {
+			placeholders = append(placeholders, entry.Placeholder)
+			events[entry.Placeholder] = entry.EventID
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Action == "" {
+			continue
+		}
+
+		if replayErr != nil {
+			pending = append(pending, entry)
+			continue
+		}
+
+		id := entry.EventID
+		if wavefront.IsPlaceholderEventID(id) {
+			if id = events[entry.EventID]; id == "" {
+				// the start before it was dropped, so there is no event to change
+				fmt.Fprintf(os.Stderr, "dropping queued %s of %s: its event was never created\n", entry.Action, entry.EventID)
+				continue
+			}
+		}
+
+		event, err := o.send(ctx, entry, id)
+		switch {
+		case errors.Is(err, wavefront.ErrValidation) || errors.Is(err, wavefront.ErrEventNotFound):
+			fmt.Fprintf(os.Stderr, "dropping queued %s, which can never succeed: %v\n", entry.Action, err)
+			continue
+		case err != nil:
+			replayErr = err
+			pending = append(pending, entry)
+			continue
+		}
+
+		replayed++
+		switch {
+		case entry.Action == START:
+			placeholders = append(placeholders, entry.Placeholder)
+			events[entry.Placeholder] = event.ID
+		case entry.Action == END && wavefront.IsPlaceholderEventID(entry.EventID):
+			// nothing else can refer to an event that has ended
+			delete(events, entry.EventID)
+		}
+	}
+
+	var kept []outboxEntry
+	for _, placeholder := range placeholders {
+		if id, ok := events[placeholder]; ok {
+			kept = append(kept, outboxEntry{Placeholder: placeholder, EventID: id})
+		}
+	}
+
+	if err = o.write(append(kept, pending...)); err != nil {
+		return replayed, len(pending), err
+	}
+
+	if replayErr != nil {
+		return replayed, len(pending), fmt.Errorf("could not replay queued put: %w", replayErr)
+	}
+
+	return replayed, len(pending), nil
+}
+
+// send makes the queued put, to the event with the given ID if it ends or checkpoints one
+func (o *outbox) send(ctx context.Context, entry outboxEntry, id string) (*wavefront.Event, error) {
+	at := time.Unix(0, entry.Time*int64(time.Millisecond))
+
+	switch entry.Action {
+	case START:
+		return o.client.CreateEvent(ctx, entry.Name, entry.Annotations, entry.Tags, at, time.Time{})
+	case CREATE:
+		return o.client.CreateEvent(ctx, entry.Name, entry.Annotations, entry.Tags, at, at.Add(time.Millisecond))
+	case END:
+		return o.client.EndEventAt(ctx, id, at, entry.Annotations)
+	case CHECKPOINT:
+		return o.client.AddCheckpoint(ctx, id, entry.Checkpoint, at)
+	default:
+		return nil, fmt.Errorf("%w: invalid action %s", wavefront.ErrValidation, entry.Action)
+	}
+}
+
+// read returns the outbox's entries, or none if it does not exist
+func (o *outbox) read() ([]outboxEntry, error) {
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open outbox: %w", err)
+	}
+	defer f.Close()
+
+	var entries []outboxEntry
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry outboxEntry
+			if jerr := json.Unmarshal(line, &entry); jerr != nil {
+				return nil, fmt.Errorf("could not parse outbox: %w", jerr)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read outbox: %w", err)
+		}
+	}
+}
+
+// write replaces the outbox's entries. The new file is moved into place once it is
+// complete, so that the outbox is never left partly written
+func (o *outbox) write(entries []outboxEntry) error {
+	tmp, err := os.Create(o.path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("could not write outbox: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	encoder := json.NewEncoder(tmp)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("could not write outbox: %w", err)
+		}
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write outbox: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("could not write outbox: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), o.path)
+}
+
+// lockOutbox waits for exclusive use of the outbox, creating its directory if needed, and
+// returns the func that releases it. The lock is a separate file, since the outbox itself
+// is replaced when it is rewritten
+func lockOutbox(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("could not create outbox directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not lock outbox: %w", err)
+	}
+
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not lock outbox: %w", err)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// softFailTimeout bounds a put that may fail, when params.timeout does not, so that a
+// Wavefront outage does not hold the build up for the whole retry policy
+const softFailTimeout = 30 * time.Second
+
+// softFail logs why a put failed, and returns a response with a placeholder version and the
+// error in its metadata, so that the build carries on
+func softFail(err error) Response {
+	fmt.Fprintf(os.Stderr, "the event could not be recorded, but fail_on_error is false: %v\n", err)
+	if hint := wavefront.Hint(err); hint != "" {
+		fmt.Fprintf(os.Stderr, "hint: %s\n", hint)
+	}
+
+	return Response{
+		Version: resource.Version{ID: wavefront.NewPlaceholderEventID(time.Now())},
+		Metadata: resource.Metadata{
+			{Name: "error", Value: err.Error()},
+		},
+	}
+}
+
+// skipPlaceholder returns the response for ending or checkpointing a placeholder, which
+// changes nothing
+func skipPlaceholder(id string, action EventAction) Response {
+	fmt.Fprintf(os.Stderr, "skipping %s: the event was never recorded\n", action)
+
+	return Response{
+		Version: resource.Version{ID: id},
+		Metadata: resource.Metadata{
+			{Name: "skipped", Value: fmt.Sprintf("%s of an event that was never recorded", action)},
+		},
+	}
+}
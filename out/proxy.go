@@ -0,0 +1,147 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// runProxyCommand sends events through the Wavefront proxy at source.proxy_address. A proxy
+// only accepts events with a known start and end, so:
+// * "create" sends an instantaneous event immediately
+// * "start" sends nothing, and records the event in the version instead
+// * "end" sends the event recorded by "start", with any new annotations merged in
+func runProxyCommand(ctx context.Context, s Request, baseDir string, hc *http.Client, envFunc func(string) string, name string, annotations map[string]string, tags []string) (Response, error) {
+	if unsupported := unsupportedByProxy(s.Params); len(unsupported) > 0 {
+		return Response{}, fmt.Errorf("%s: %w", strings.Join(unsupported, ", "), wavefront.ErrUnsupportedByProxy)
+	}
+
+	proxy, err := wavefront.NewProxyClient(s.Source.ProxyAddress, hc)
+	if err != nil {
+		return Response{}, err
+	}
+
+	var event *wavefront.Event
+
+	switch s.Params.Action {
+	case CREATE:
+		start := time.Now()
+		end := start.Add(time.Millisecond)
+
+		if err = proxy.SendEvent(ctx, name, annotations, tags, start, end); err != nil {
+			return Response{}, fmt.Errorf("could not send event to proxy: %w", err)
+		}
+
+		event, err = wavefront.NewProxyEvent(name, annotations, tags, start, end)
+	case START:
+		event, err = wavefront.NewProxyEvent(name, annotations, tags, time.Now(), time.Time{})
+	case END:
+		if event, err = endProxyEvent(ctx, proxy, s, baseDir, annotations); err != nil {
+			return Response{}, err
+		}
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("could not record event: %w", err)
+	}
+
+	metadata, err := wavefront.GetConcourseMetadata(event, s.Source)
+	if err != nil {
+		return Response{}, fmt.Errorf("could not determine event state: %w", err)
+	}
+
+	if s.Params.Action == END && s.Params.EmitMetrics {
+		points, err := jobMetrics(event, s.Params.JobResult, envFunc)
+		if err != nil {
+			return Response{}, fmt.Errorf("event was sent, but job metrics could not be calculated: %w", err)
+		}
+
+		if err = proxy.SendMetrics(ctx, points); err != nil {
+			return Response{}, fmt.Errorf("event was sent, but job metrics could not be sent: %w", err)
+		}
+	}
+
+	return Response{
+		Version:  resource.Version{ID: event.ID},
+		Metadata: metadata,
+	}, nil
+}
+
+// unsupportedByProxy lists the parameters that are set but need the REST API
+func unsupportedByProxy(p Params) []string {
+	var unsupported []string
+
+	if p.Action == CHECKPOINT {
+		unsupported = append(unsupported, "action: checkpoint")
+	}
+
+	if p.Trace != "" {
+		unsupported = append(unsupported, "trace")
+	}
+
+	if p.Parent != "" {
+		unsupported = append(unsupported, "parent")
+	}
+
+	if p.OpenChildren != "" {
+		unsupported = append(unsupported, "open_children")
+	}
+
+	if p.Idempotent || p.IdempotencyKey != "" {
+		unsupported = append(unsupported, "idempotent")
+	}
+
+	return unsupported
+}
+
+func endProxyEvent(ctx context.Context, proxy *wavefront.ProxyClient, s Request, baseDir string, annotations map[string]string) (*wavefront.Event, error) {
+	id, err := readEventID(baseDir, s.Params.Event)
+	if err != nil {
+		return nil, fmt.Errorf("could not read event ID to close: %w", err)
+	}
+
+	if !wavefront.IsProxyEventID(id) {
+		return nil, fmt.Errorf("event %s was not started through a proxy: %w", id, wavefront.ErrUnsupportedByProxy)
+	}
+
+	started, err := wavefront.DecodeProxyEventID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if started.RunningState != wavefront.StateOngoing {
+		return nil, fmt.Errorf("event %s has already ended", s.Params.Event)
+	}
+
+	name, start, tags, finalAnnotations := started.Name, started.Start(), started.Tags, started.Annotations
+
+	// if there are no annotations here, we want to do nothing to them in the end event
+	if s.Params.Annotations != nil {
+		updates := map[string]string{}
+		for k, v := range annotations {
+			updates[k] = v
+		}
+
+		for k, v := range s.Params.Annotations {
+			if v == "" {
+				updates[k] = ""
+			}
+		}
+
+		finalAnnotations = wavefront.MergeAnnotations(finalAnnotations, updates)
+	}
+
+	end := time.Now()
+	if err = proxy.SendEvent(ctx, name, finalAnnotations, tags, start, end); err != nil {
+		return nil, fmt.Errorf("could not send event to proxy: %w", err)
+	}
+
+	return wavefront.NewProxyEvent(name, finalAnnotations, tags, start, end)
+}
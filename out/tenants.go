@@ -0,0 +1,228 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// tenantResult is the outcome of a put in one tenant
+type tenantResult struct {
+	response Response
+	err      error
+	// skipped is set if there was no event in the tenant to end or checkpoint
+	skipped bool
+}
+
+// runTenantsCommand makes the put in each of source.tenants at once, each with its own
+// annotation overrides. Previous get steps hold each tenant's event in tenants/<name>, so
+// the event, parent and parent_event directories are read from there.
+//
+// If source.preflight is set, every tenant is checked before any is changed, and tenants
+// that fail the check are skipped. Whether the put succeeds when it fails in some tenants is
+// decided by source.tenant_policy. The version's ID is the event in the first tenant that
+// succeeded, its metadata is that event's, and tenants that failed are listed in the
+// failed_tenants metadata.
+//
+// Under tenant_policy any or primary, a start can succeed without creating the event in
+// every tenant, so ending or checkpointing it skips the tenants that previous get steps
+// recorded no event for, rather than counting them as failures. Under primary, the put
+// still fails if the primary tenant has no event
+func runTenantsCommand(ctx context.Context, s Request, baseDir string, hc *http.Client, envFunc func(string) string, name string, annotations map[string]string, tags []string) (Response, error) {
+	tenants := s.Source.Tenants
+	requests := make([]Request, len(tenants))
+	tenantAnnotations := make([]map[string]string, len(tenants))
+	clients := make([]*wavefront.APIClient, len(tenants))
+
+	for i, t := range tenants {
+		requests[i] = tenantRequest(s, baseDir, t)
+
+		tenantAnnotations[i] = make(map[string]string, len(annotations))
+		for k, v := range annotations {
+			tenantAnnotations[i][k] = v
+		}
+
+		if err := overrideAnnotations(tenantAnnotations[i], t.Annotations, envFunc); err != nil {
+			return Response{}, fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+
+		clients[i] = wavefront.NewAPIClient(requests[i].Source, hc)
+	}
+
+	results := make([]tenantResult, len(tenants))
+
+	// under tenant_policy all, a missing event is an error like any other
+	policy := s.Source.GetTenantPolicy()
+	if (s.Params.Action == END || s.Params.Action == CHECKPOINT) && policy != resource.ALL {
+		for i, t := range tenants {
+			if _, err := os.Stat(filepath.Join(baseDir, requests[i].Params.Event, "id")); err == nil {
+				continue
+			}
+
+			// the put must succeed in the primary tenant, so it cannot be skipped
+			if policy == resource.PRIMARY && i == 0 {
+				results[i].err = ErrMissingTenantEvent
+				continue
+			}
+
+			fmt.Fprintf(os.Stderr, "tenant %s: skipping %s, since the event was not created in this tenant\n", t.Name, s.Params.Action)
+			results[i].skipped = true
+		}
+	}
+
+	if s.Source.Preflight {
+		forEachTenant(len(tenants), func(i int) {
+			if !results[i].skipped && results[i].err == nil {
+				results[i].err = clients[i].Preflight(ctx)
+			}
+		})
+
+		if err := checkTenantPolicy(s.Source, results); err != nil {
+			return Response{}, err
+		}
+	}
+
+	forEachTenant(len(tenants), func(i int) {
+		if results[i].skipped || results[i].err != nil {
+			return
+		}
+
+		results[i].response, results[i].err = runAPICommand(ctx, clients[i], requests[i], baseDir, hc, envFunc, name, tenantAnnotations[i], tags)
+	})
+
+	if err := checkTenantPolicy(s.Source, results); err != nil {
+		return Response{}, err
+	}
+
+	var (
+		response *Response
+		failed   []string
+	)
+
+	ids := map[string]string{}
+	for i, t := range tenants {
+		if results[i].skipped {
+			continue
+		}
+
+		if results[i].err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", t.Name, results[i].err))
+			continue
+		}
+
+		ids[t.Name] = results[i].response.Version.ID
+		if response == nil {
+			response = &results[i].response
+		}
+	}
+
+	if response == nil {
+		return Response{}, fmt.Errorf("put did not succeed in any tenant: %w", ErrNoTenantEvents)
+	}
+
+	response.Version.TenantIDs = resource.FormatTenantIDs(ids)
+	if len(failed) > 0 {
+		response.Metadata = append(response.Metadata, resource.Metadatum{Name: "failed_tenants", Value: strings.Join(failed, "; ")})
+	}
+
+	return *response, nil
+}
+
+// tenantRequest returns the request as it applies to one tenant
+func tenantRequest(s Request, baseDir string, t resource.Tenant) Request {
+	tenant := s
+	tenant.Source = s.Source.ForTenant(t)
+
+	// the event to end or checkpoint must be the one in this tenant
+	if s.Params.Event != "" {
+		tenant.Params.Event = filepath.Join(s.Params.Event, "tenants", t.Name)
+	}
+
+	// parents may also be event IDs, or get steps of a single tenant
+	tenant.Params.Parent = tenantLocator(baseDir, s.Params.Parent, t)
+	tenant.Params.ParentEvent = tenantLocator(baseDir, s.Params.ParentEvent, t)
+
+	return tenant
+}
+
+// tenantLocator returns the tenant's directory within the get step's directory at locator,
+// or locator as it is if there is no such directory
+func tenantLocator(baseDir string, locator string, t resource.Tenant) string {
+	if locator == "" {
+		return locator
+	}
+
+	dir := filepath.Join(locator, "tenants", t.Name)
+	if _, err := os.Stat(filepath.Join(baseDir, dir, "id")); err != nil {
+		return locator
+	}
+
+	return dir
+}
+
+// forEachTenant calls f with the index of each of n tenants at once, and waits for every call
+// to return
+func forEachTenant(n int, f func(i int)) {
+	var wg sync.WaitGroup
+
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// checkTenantPolicy returns an error for the tenants that failed if, under the source's
+// tenant policy, the put cannot succeed. Skipped tenants neither succeed nor fail, but the
+// put must be made in at least one tenant. The error wraps that of the first tenant to fail
+func checkTenantPolicy(source resource.Source, results []tenantResult) error {
+	var (
+		failed []int
+		made   int
+	)
+
+	for i := range results {
+		if results[i].skipped {
+			continue
+		}
+
+		made++
+		if results[i].err != nil {
+			failed = append(failed, i)
+		}
+	}
+
+	policy := source.GetTenantPolicy()
+
+	switch {
+	case made == 0:
+		return fmt.Errorf("put did not succeed in any tenant: %w", ErrNoTenantEvents)
+	case len(failed) == 0:
+		return nil
+	case policy == resource.ANY && len(failed) < made:
+		return nil
+	case policy == resource.PRIMARY && failed[0] != 0:
+		return nil
+	}
+
+	err := fmt.Errorf("tenant %s: %w", source.Tenants[failed[0]].Name, results[failed[0]].err)
+	for _, i := range failed[1:] {
+		err = fmt.Errorf("%w; tenant %s: %v", err, source.Tenants[i].Name, results[i].err)
+	}
+
+	return fmt.Errorf("put did not succeed in enough tenants for tenant_policy %s: %w", policy, err)
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package out
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// Annotations used to record an event's place in a trace
+const (
+	traceIDAnnotation      = "trace-id"
+	spanIDAnnotation       = "span-id"
+	parentSpanIDAnnotation = "parent-span-id"
+)
+
+// addTraceAnnotations generates a span ID for a new event and records it in annotations. If
+// parentLocator is set, the event joins the parent event's trace as a child of its span;
+// otherwise a new trace is started
+func addTraceAnnotations(ctx context.Context, client *wavefront.APIClient, baseDir string, parentLocator string, annotations map[string]string) error {
+	spanID, err := wavefront.NewSpanID()
+	if err != nil {
+		return err
+	}
+
+	annotations[spanIDAnnotation] = spanID
+
+	if parentLocator == "" {
+		traceID, err := wavefront.NewTraceID()
+		if err != nil {
+			return err
+		}
+
+		annotations[traceIDAnnotation] = traceID
+		return nil
+	}
+
+	_, parent, err := loadEvent(ctx, client, baseDir, parentLocator)
+	if err != nil {
+		return fmt.Errorf("could not load parent event: %w", err)
+	}
+
+	parentAnnotations := parent.Annotations
+	if parentAnnotations[traceIDAnnotation] == "" || parentAnnotations[spanIDAnnotation] == "" {
+		return fmt.Errorf("parent event %s was not traced", parentLocator)
+	}
+
+	annotations[traceIDAnnotation] = parentAnnotations[traceIDAnnotation]
+	annotations[parentSpanIDAnnotation] = parentAnnotations[spanIDAnnotation]
+	return nil
+}
+
+// sendSpan sends a span covering the event's duration, using the IDs recorded by addTraceAnnotations
+func sendSpan(ctx context.Context, client *wavefront.APIClient, hc *http.Client, source resource.Source, format TraceFormat, event *wavefront.Event) error {
+	annotations := event.Annotations
+
+	if annotations[traceIDAnnotation] == "" || annotations[spanIDAnnotation] == "" {
+		return fmt.Errorf("event has no %s or %s annotation; was it started with trace set?", traceIDAnnotation, spanIDAnnotation)
+	}
+
+	span := wavefront.Span{
+		Name:         event.Name,
+		TraceID:      annotations[traceIDAnnotation],
+		SpanID:       annotations[spanIDAnnotation],
+		ParentSpanID: annotations[parentSpanIDAnnotation],
+		Start:        event.Start(),
+		End:          event.End(),
+		Service:      annotations["concourse-pipeline"],
+		Tags: map[string]string{
+			"concourse.team":      annotations["concourse-team"],
+			"concourse.pipeline":  annotations["concourse-pipeline"],
+			"concourse.job":       annotations["concourse-job"],
+			"concourse.build_url": annotations["concourse-build-url"],
+		},
+		Error: wavefront.IsFailureSeverity(annotations["severity"], nil),
+	}
+
+	if format == OTLP {
+		otlpClient, err := wavefront.NewOTLPClient(source, hc)
+		if err != nil {
+			return err
+		}
+
+		return wavefront.SendOTLPSpans(ctx, otlpClient, source.OTLPEndpoint, []wavefront.Span{span})
+	}
+
+	return client.SendSpans(ctx, []wavefront.Span{span})
+}
+
+// loadEvent finds an event by locator, which is either the directory of a previous get
+// step, or an event ID to fetch from the API
+func loadEvent(ctx context.Context, client *wavefront.APIClient, baseDir string, locator string) (string, *wavefront.Event, error) {
+	if _, err := os.Stat(filepath.Join(baseDir, locator, "id")); err != nil {
+		event, err := client.GetEvent(ctx, locator)
+		return locator, event, err
+	}
+
+	id, err := readEventID(baseDir, locator)
+	if err != nil {
+		return "", nil, err
+	}
+
+	event, err := readEvent(baseDir, locator)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return id, event, nil
+}
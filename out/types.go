@@ -6,44 +6,127 @@ package out
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
+	"time"
 
 	resource "github.com/vmware-tanzu/observability-event-resource"
 )
 
 // Params indicates what should be done
 type Params struct {
-	Action      EventAction       `json:"action"`
-	Name        string            `json:"event_name"`
-	Annotations map[string]string `json:"annotations,omitempty"`
-	Tags        []string          `json:"tags"`
-	Event       string            `json:"event"`
+	Action       EventAction       `json:"action"`
+	Name         string            `json:"event_name"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Tags         []string          `json:"tags"`
+	Event        string            `json:"event"`
+	EmitMetrics  bool              `json:"emit_metrics,omitempty"`
+	JobResult    JobResult         `json:"job_result,omitempty"`
+	Trace        TraceFormat       `json:"trace,omitempty"`
+	ParentEvent  string            `json:"parent_event,omitempty"`
+	Parent       string            `json:"parent,omitempty"`
+	OpenChildren ChildPolicy       `json:"open_children,omitempty"`
+	Checkpoint   string            `json:"checkpoint,omitempty"`
+	Timeout      string            `json:"timeout,omitempty"`
+
+	Idempotent     bool   `json:"idempotent,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// FailOnError overrides source.fail_on_error for this put
+	FailOnError *bool `json:"fail_on_error,omitempty"`
+	// Outbox overrides source.outbox for this put, and must also be an absolute path
+	Outbox string `json:"outbox,omitempty"`
 }
 
 // Validate will ensure that all required properties are set in a put's "params" block
 func (p Params) Validate() error {
 	if p.Action != START &&
 		p.Action != END &&
-		p.Action != CREATE {
+		p.Action != CREATE &&
+		p.Action != CHECKPOINT &&
+		p.Action != REPLAY {
 		return fmt.Errorf("invalid action %s", p.Action)
 	}
 
-	if p.Action == END && p.Event == "" {
-		return errors.New(`the "event" parameter must be set when "action" is "end"`)
+	if (p.Action == END || p.Action == CHECKPOINT) && p.Event == "" {
+		return errors.New(`the "event" parameter must be set when "action" is "end" or "checkpoint"`)
+	}
+
+	if p.Action == CHECKPOINT && p.Checkpoint == "" {
+		return errors.New(`the "checkpoint" parameter must be set when "action" is "checkpoint"`)
 	}
 
 	if (p.Action == START || p.Action == CREATE) && p.Name == "" {
 		return errors.New(`the "event_name" parameter must be set when "action" is "start" or "create"`)
 	}
 
+	if p.JobResult != "" && p.JobResult != SUCCEEDED && p.JobResult != FAILED {
+		return fmt.Errorf(`invalid job_result %s, must be "succeeded" or "failed"`, p.JobResult)
+	}
+
+	if p.Trace != "" && p.Trace != WAVEFRONT && p.Trace != OTLP {
+		return fmt.Errorf(`invalid trace %s, must be "wavefront" or "otlp"`, p.Trace)
+	}
+
+	if p.Parent != "" && p.Action != START && p.Action != CREATE {
+		return errors.New(`the "parent" parameter may only be set when "action" is "start" or "create"`)
+	}
+
+	if p.OpenChildren != "" && p.OpenChildren != IGNORE && p.OpenChildren != REFUSE && p.OpenChildren != CASCADE {
+		return fmt.Errorf(`invalid open_children %s, must be "ignore", "refuse", or "cascade"`, p.OpenChildren)
+	}
+
+	if p.Outbox != "" && !filepath.IsAbs(p.Outbox) {
+		return fmt.Errorf("invalid outbox %s, must be an absolute path", p.Outbox)
+	}
+
+	if p.Timeout != "" {
+		if timeout, err := time.ParseDuration(p.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %s, must be a positive duration", p.Timeout)
+		}
+	}
+
+	if p.ParentEvent != "" && (p.Trace == "" || (p.Action != START && p.Action != CREATE)) {
+		return errors.New(`the "parent_event" parameter may only be set when "trace" is set and "action" is "start" or "create"`)
+	}
+
 	return nil
 }
 
+// GetTimeout parses the timeout parameter, returning 0 if it was not set
+func (p Params) GetTimeout() time.Duration {
+	timeout, _ := time.ParseDuration(p.Timeout)
+	return timeout
+}
+
 // Request is what is received on stdin from the pipeline
 type Request struct {
 	Source resource.Source `json:"source"`
 	Params Params          `json:"params"`
 }
 
+// FailOnError reports whether a failed put should fail, as set by params.fail_on_error, or
+// else by source.fail_on_error. Defaults to true
+func (r Request) FailOnError() bool {
+	switch {
+	case r.Params.FailOnError != nil:
+		return *r.Params.FailOnError
+	case r.Source.FailOnError != nil:
+		return *r.Source.FailOnError
+	default:
+		return true
+	}
+}
+
+// OutboxPath returns the outbox set by params.outbox, or else by source.outbox, or "" if
+// neither is set
+func (r Request) OutboxPath() string {
+	if r.Params.Outbox != "" {
+		return r.Params.Outbox
+	}
+
+	return r.Source.Outbox
+}
+
 // Response is a version and a set of metadata
 type Response struct {
 	Version  resource.Version  `json:"version"`
@@ -62,4 +145,55 @@ const (
 
 	// CREATE will create an instantaneous event
 	CREATE EventAction = "create"
+
+	// CHECKPOINT will append a timestamped entry to an ONGOING event's timeline
+	CHECKPOINT EventAction = "checkpoint"
+
+	// REPLAY will resubmit the puts queued in the outbox
+	REPLAY EventAction = "replay"
+)
+
+// JobResult is the outcome of a job, reported in the concourse.job.result metric
+type JobResult string
+
+const (
+	// SUCCEEDED is reported as a concourse.job.result value of 1
+	SUCCEEDED JobResult = "succeeded"
+
+	// FAILED is reported as a concourse.job.result value of 0
+	FAILED JobResult = "failed"
+)
+
+// TraceFormat selects how spans are sent
+type TraceFormat string
+
+const (
+	// WAVEFRONT sends spans to the tenant in Wavefront span format
+	WAVEFRONT TraceFormat = "wavefront"
+
+	// OTLP sends spans to source.otlp_endpoint as OTLP/HTTP JSON
+	OTLP TraceFormat = "otlp"
+)
+
+// ChildPolicy decides what happens when a parent event is closed while its children are ongoing
+type ChildPolicy string
+
+const (
+	// IGNORE closes the parent regardless of its children, and is the default
+	IGNORE ChildPolicy = "ignore"
+
+	// REFUSE fails without closing the parent
+	REFUSE ChildPolicy = "refuse"
+
+	// CASCADE closes every ongoing child before closing the parent
+	CASCADE ChildPolicy = "cascade"
 )
+
+// ErrMissingTenantEvent will be returned when an event to end or checkpoint was not created in the primary tenant
+var ErrMissingTenantEvent = errors.New("the event was not created in this tenant")
+
+// ErrNoTenantEvents will be returned when an event to end or checkpoint was not created in any tenant
+var ErrNoTenantEvents = errors.New("the event was not created in any tenant")
+
+// ErrOpenChildren will be returned when a parent event cannot be closed because some of its children are ongoing
+var ErrOpenChildren = errors.New("child events are still ongoing")
@@ -4,8 +4,14 @@
 package resource
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // AppVersion will be specified by the build
@@ -19,28 +25,360 @@ var AppVersion = "0.0.0-dev"
 //		  source:
 //			tenant_url: http://<mywavefronttenant>.wavefront.com
 //			api_token: ((my-secret-token))
+//
+// Instead of api_token, the resource can authenticate with VMware Cloud Services, using
+// either csp_client_id and csp_client_secret, or csp_api_token.
+//
+// To send each event to several tenants, list them in tenants instead of setting tenant_url.
+// A tenant without an api_token uses the source's credentials
+//
+//		  source:
+//			api_token: ((shared-token))
+//			tenant_policy: primary
+//			tenants:
+//			- name: prod
+//			  url: https://prod.wavefront.com
+//			  api_token: ((prod-token))
+//			- name: platform
+//			  url: https://platform.wavefront.com
+//			  annotations:
+//			    severity: warn
 type Source struct {
-	WavefrontURL   string `json:"tenant_url"`
-	WavefrontToken string `json:"api_token"`
-	Debug          bool   `json:"debug"`
+	WavefrontURL   string   `json:"tenant_url"`
+	WavefrontToken string   `json:"api_token"`
+	Debug          bool     `json:"debug"`
+	MetadataFields []string `json:"metadata_fields,omitempty"`
+	Dashboard      string   `json:"dashboard,omitempty"`
+	ProxyAddress   string   `json:"proxy_address,omitempty"`
+	OTLPEndpoint   string   `json:"otlp_endpoint,omitempty"`
+
+	CSPURL          string `json:"csp_url,omitempty"`
+	CSPClientID     string `json:"csp_client_id,omitempty"`
+	CSPClientSecret string `json:"csp_client_secret,omitempty"`
+	CSPAPIToken     string `json:"csp_api_token,omitempty"`
+
+	// Preflight checks that the tenant can be reached and the token may manage events, when
+	// the resource is checked and before each put
+	Preflight bool `json:"preflight,omitempty"`
+
+	// FailOnError, if false, makes a put that fails succeed with a placeholder version
+	// instead, unless the put's params set fail_on_error. Defaults to true
+	FailOnError *bool `json:"fail_on_error,omitempty"`
+
+	// Outbox is a file that puts which fail with fail_on_error false are queued in, to be
+	// replayed later. It must be an absolute path on storage that outlives the build, since
+	// the put's build directory is discarded when the put finishes
+	Outbox string `json:"outbox,omitempty"`
+
+	// SecretAnnotations are the keys of annotations whose values are redacted from the
+	// debug trace
+	SecretAnnotations []string `json:"secret_annotations,omitempty"`
+
+	Retry          RetryConfig `json:"retry,omitempty"`
+	RequestTimeout string      `json:"request_timeout,omitempty"`
+
+	TLSConfig
+	HTTPProxy string `json:"http_proxy,omitempty"`
+	NoProxy   string `json:"no_proxy,omitempty"`
+
+	Tenants      []Tenant     `json:"tenants,omitempty"`
+	TenantPolicy TenantPolicy `json:"tenant_policy,omitempty"`
+}
+
+// Tenant is one of the tenants that a put sends its event to. The first tenant is the primary
+type Tenant struct {
+	// Name identifies the tenant in versions and in the output of a get
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"api_token,omitempty"`
+	// Annotations override the put's annotations in this tenant. An empty value removes one
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// TenantPolicy decides whether a put to several tenants succeeds when it fails in some of them
+type TenantPolicy string
+
+const (
+	// ALL requires the put to succeed in every tenant, and is the default
+	ALL TenantPolicy = "all"
+
+	// ANY requires the put to succeed in at least one tenant
+	ANY TenantPolicy = "any"
+
+	// PRIMARY requires the put to succeed in the first tenant
+	PRIMARY TenantPolicy = "primary"
+)
+
+// GetTenantPolicy returns the tenant policy, or ALL if it is not set
+func (s Source) GetTenantPolicy() TenantPolicy {
+	if s.TenantPolicy == "" {
+		return ALL
+	}
+
+	return s.TenantPolicy
+}
+
+// ForTenant returns the source with the tenant's URL, and its token if it has one, in place
+// of the tenant list
+func (s Source) ForTenant(t Tenant) Source {
+	tenant := s
+	tenant.Tenants = nil
+	tenant.TenantPolicy = ""
+	tenant.WavefrontURL = t.URL
+
+	if t.Token != "" {
+		tenant.WavefrontToken = t.Token
+		tenant.CSPClientID, tenant.CSPClientSecret, tenant.CSPAPIToken = "", "", ""
+	}
+
+	return tenant
+}
+
+// validateTenants ensures that the tenant policy is known, and that every tenant has a
+// unique name and could be used on its own
+func (s Source) validateTenants() error {
+	switch s.GetTenantPolicy() {
+	case ALL, ANY, PRIMARY:
+	default:
+		return fmt.Errorf("could not validate source configuration: %w: unknown tenant_policy %q", ErrInvalidTenants, s.TenantPolicy)
+	}
+
+	if s.WavefrontURL != "" {
+		return fmt.Errorf("could not validate source configuration: %w: tenant_url cannot be set with tenants", ErrInvalidTenants)
+	}
+
+	names := map[string]bool{}
+	for _, t := range s.Tenants {
+		if t.Name == "" || strings.ContainsAny(t.Name, `/\`) || t.Name == "." || t.Name == ".." {
+			return fmt.Errorf("could not validate source configuration: %w: tenant name %q must be set and must not be a path", ErrInvalidTenants, t.Name)
+		}
+
+		if names[t.Name] {
+			return fmt.Errorf("could not validate source configuration: %w: tenant name %q is used more than once", ErrInvalidTenants, t.Name)
+		}
+		names[t.Name] = true
+
+		if err := s.ForTenant(t).Validate(); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// RetryConfig tunes how failed API requests are retried. Durations use Go's duration
+// syntax, such as "500ms" or "2m", and unset fields keep their defaults
+//
+//		retry:
+//		  max_elapsed_time: 5m
+//		  initial_interval: 1s
+//		  retryable_status_codes: [429, 503]
+type RetryConfig struct {
+	MaxElapsedTime       string `json:"max_elapsed_time,omitempty"`
+	InitialInterval      string `json:"initial_interval,omitempty"`
+	RetryableStatusCodes []int  `json:"retryable_status_codes,omitempty"`
+}
+
+// TLSConfig customizes the TLS connections made to the tenant. Its keys are set directly in
+// the source:
+//
+//		ca_cert: ((private-ca.certificate))
+//		client_cert: ((client.certificate))
+//		client_key: ((client.private_key))
+type TLSConfig struct {
+	// CACert is a PEM encoded CA certificate to trust, as well as the system's CAs
+	CACert string `json:"ca_cert,omitempty"`
+	// ClientCert and ClientKey are a PEM encoded certificate and key to present to the server
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+	// InsecureSkipVerify disables verification of the server's certificate
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// IsSet reports whether any TLS setting differs from the default
+func (t TLSConfig) IsSet() bool {
+	return t.CACert != "" || t.ClientCert != "" || t.ClientKey != "" || t.InsecureSkipVerify
 }
 
-// Validate ensures that the source's required properties are set
+// Validate ensures that the certificates and key can be parsed
+func (t TLSConfig) Validate() error {
+	if t.CACert != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(t.CACert)) {
+		return fmt.Errorf("%w: ca_cert does not contain a PEM encoded certificate", ErrInvalidTLSConfig)
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		if _, err := tls.X509KeyPair([]byte(t.ClientCert), []byte(t.ClientKey)); err != nil {
+			return fmt.Errorf("%w: client_cert and client_key must be a PEM encoded certificate and its key: %v", ErrInvalidTLSConfig, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate ensures that the durations can be parsed and the status codes are HTTP error
+// statuses. max_elapsed_time may be 0, to retry until the request succeeds, but
+// initial_interval may not
+func (r RetryConfig) Validate() error {
+	if r.MaxElapsedTime != "" {
+		if d, err := time.ParseDuration(r.MaxElapsedTime); err != nil || d < 0 {
+			return fmt.Errorf("%w: max_elapsed_time must be a non-negative duration, got %q", ErrInvalidRetryConfig, r.MaxElapsedTime)
+		}
+	}
+
+	if r.InitialInterval != "" {
+		if d, err := time.ParseDuration(r.InitialInterval); err != nil || d <= 0 {
+			return fmt.Errorf("%w: initial_interval must be a positive duration, got %q", ErrInvalidRetryConfig, r.InitialInterval)
+		}
+	}
+
+	for _, code := range r.RetryableStatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("%w: %d is not an HTTP error status", ErrInvalidRetryConfig, code)
+		}
+	}
+
+	return nil
+}
+
+// UsesCSP reports whether the source authenticates with VMware Cloud Services rather than
+// an API token
+func (s Source) UsesCSP() bool {
+	return s.CSPClientID != "" || s.CSPClientSecret != "" || s.CSPAPIToken != ""
+}
+
+// validateCredentials ensures that exactly one way of authenticating is configured
+func (s Source) validateCredentials() error {
+	methods := 0
+	for _, set := range []bool{s.WavefrontToken != "", s.CSPClientID != "" || s.CSPClientSecret != "", s.CSPAPIToken != ""} {
+		if set {
+			methods++
+		}
+	}
+
+	switch {
+	case methods == 0:
+		return ErrMissingWavefrontToken
+	case methods > 1:
+		return ErrConflictingCredentials
+	case (s.CSPClientID == "") != (s.CSPClientSecret == ""):
+		return ErrIncompleteCSPCredentials
+	}
+
+	if s.CSPURL != "" {
+		if u, err := url.Parse(s.CSPURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: %q", ErrInvalidCSPURL, s.CSPURL)
+		}
+	}
+
+	return nil
+}
+
+// AllMetadataFields lists every metadata field that can be shown in Concourse, in the
+// order they are shown by default
+var AllMetadataFields = []string{"name", "state", "severity", "start_time", "end_time", "duration", "tags", "url"}
+
+// Validate ensures that the source's required properties are set. When sending through a
+// proxy, the tenant URL and token are not required. When sending to several tenants, each
+// tenant is validated as if it were the only one
 func (s Source) Validate() error {
+	if s.ProxyAddress != "" {
+		if len(s.Tenants) > 0 {
+			return fmt.Errorf("could not validate source configuration: %w: tenants cannot be used with proxy_address", ErrInvalidTenants)
+		}
+
+		return s.validateMetadataFields()
+	}
+
+	if len(s.Tenants) > 0 {
+		return s.validateTenants()
+	}
+
+	if err := s.Retry.Validate(); err != nil {
+		return fmt.Errorf("could not validate source configuration: %w", err)
+	}
+
+	if err := s.TLSConfig.Validate(); err != nil {
+		return fmt.Errorf("could not validate source configuration: %w", err)
+	}
+
+	if s.HTTPProxy != "" {
+		if u, err := url.Parse(s.HTTPProxy); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("could not validate source configuration: %w: %q", ErrInvalidProxy, s.HTTPProxy)
+		}
+	}
+
+	if s.RequestTimeout != "" {
+		if d, err := time.ParseDuration(s.RequestTimeout); err != nil || d < 0 {
+			return fmt.Errorf("could not validate source configuration: %w: %q", ErrInvalidRequestTimeout, s.RequestTimeout)
+		}
+	}
+
+	if s.Outbox != "" && !filepath.IsAbs(s.Outbox) {
+		return fmt.Errorf("could not validate source configuration: %w: %q", ErrInvalidOutbox, s.Outbox)
+	}
+
 	if s.WavefrontURL == "" {
 		return fmt.Errorf("could not validate source configuration: %w", ErrMissingWavefrontURL)
 	}
 
-	if s.WavefrontToken == "" {
-		return fmt.Errorf("could not validate source configuration: %w", ErrMissingWavefrontToken)
+	if err := s.validateCredentials(); err != nil {
+		return fmt.Errorf("could not validate source configuration: %w", err)
+	}
+
+	return s.validateMetadataFields()
+}
+
+func (s Source) validateMetadataFields() error {
+	for _, field := range s.MetadataFields {
+		if !isMetadataField(field) {
+			return fmt.Errorf("could not validate source configuration: %w: %s", ErrInvalidMetadataField, field)
+		}
 	}
 
 	return nil
 }
 
-// Version is used by the in and out script and represents an event's ID
+func isMetadataField(field string) bool {
+	for _, f := range AllMetadataFields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Version is used by the in and out script and represents an event's ID. When the source has
+// tenants, ID is the event's ID in the first tenant it was created in, and TenantIDs holds its
+// ID in each tenant, as a query string such as "platform=<id>&prod=<id>"
 type Version struct {
-	ID string `json:"id"`
+	ID        string `json:"id"`
+	TenantIDs string `json:"tenant_ids,omitempty"`
+}
+
+// FormatTenantIDs encodes the event IDs of each tenant for Version.TenantIDs
+func FormatTenantIDs(ids map[string]string) string {
+	values := url.Values{}
+	for name, id := range ids {
+		values.Set(name, id)
+	}
+
+	return values.Encode()
+}
+
+// ParseTenantIDs decodes Version.TenantIDs into the event ID of each tenant
+func (v Version) ParseTenantIDs() (map[string]string, error) {
+	values, err := url.ParseQuery(v.TenantIDs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant_ids %q: %w", v.TenantIDs, err)
+	}
+
+	ids := make(map[string]string, len(values))
+	for name := range values {
+		ids[name] = values.Get(name)
+	}
+
+	return ids, nil
 }
 
 // Metadatum is a key value pair
@@ -57,5 +395,38 @@ type Metadata []Metadatum
 // ErrMissingWavefrontURL will be emitted or wrapped when the source is missing the wavefront URL
 var ErrMissingWavefrontURL = errors.New("wavefront url is missing")
 
-// ErrMissingWavefrontToken will be emitted or wrapped when the source is missing the wavefront token
+// ErrMissingWavefrontToken will be emitted or wrapped when the source has neither a wavefront
+// token nor CSP credentials
 var ErrMissingWavefrontToken = errors.New("wavefront token is missing")
+
+// ErrConflictingCredentials will be emitted or wrapped when the source configures more than one
+// way of authenticating
+var ErrConflictingCredentials = errors.New("only one of api_token, csp_client_id and csp_client_secret, or csp_api_token may be set")
+
+// ErrIncompleteCSPCredentials will be emitted or wrapped when only one of the CSP client ID and
+// secret is set
+var ErrIncompleteCSPCredentials = errors.New("csp_client_id and csp_client_secret must be set together")
+
+// ErrInvalidTLSConfig will be emitted or wrapped when a certificate or key in the source cannot be parsed
+var ErrInvalidTLSConfig = errors.New("invalid TLS configuration")
+
+// ErrInvalidProxy will be emitted or wrapped when http_proxy is not an absolute URL
+var ErrInvalidProxy = errors.New("http_proxy must be an absolute URL")
+
+// ErrInvalidCSPURL will be emitted or wrapped when the CSP URL is not an absolute URL
+var ErrInvalidCSPURL = errors.New("csp_url must be an absolute URL")
+
+// ErrInvalidTenants will be emitted or wrapped when the source's tenants cannot be used
+var ErrInvalidTenants = errors.New("invalid tenants")
+
+// ErrInvalidMetadataField will be emitted or wrapped when the source requests an unknown metadata field
+var ErrInvalidMetadataField = errors.New("invalid metadata field")
+
+// ErrInvalidRetryConfig will be emitted or wrapped when the source's retry settings cannot be used
+var ErrInvalidRetryConfig = errors.New("invalid retry configuration")
+
+// ErrInvalidOutbox will be emitted or wrapped when the outbox is not an absolute path
+var ErrInvalidOutbox = errors.New("outbox must be an absolute path")
+
+// ErrInvalidRequestTimeout will be emitted or wrapped when the source's request_timeout is not a duration
+var ErrInvalidRequestTimeout = errors.New("invalid request timeout")
@@ -0,0 +1,107 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkpointAnnotationPrefix is followed by the checkpoint's number to form its annotation key
+const checkpointAnnotationPrefix = "checkpoint-"
+
+// checkpointAttempts bounds how many times AddCheckpoint retries when a concurrent update wins
+const checkpointAttempts = 5
+
+// Checkpoint is a timestamped entry in an event's timeline, stored in a checkpoint-N annotation
+// with the value "<RFC 3339 time> <message>"
+type Checkpoint struct {
+	Number  int
+	Time    time.Time
+	Message string
+}
+
+// String formats the checkpoint as it is stored in its annotation
+func (c Checkpoint) String() string {
+	return fmt.Sprintf("%s %s", c.Time.UTC().Format(time.RFC3339), c.Message)
+}
+
+// Timeline returns the event's checkpoints, in order
+func (e Event) Timeline() []Checkpoint {
+	var timeline []Checkpoint
+	for k, v := range e.Annotations {
+		if !strings.HasPrefix(k, checkpointAnnotationPrefix) {
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimPrefix(k, checkpointAnnotationPrefix))
+		if err != nil || n <= 0 {
+			continue
+		}
+
+		c := Checkpoint{Number: n, Message: v}
+		if parts := strings.SplitN(v, " ", 2); len(parts) == 2 {
+			if t, err := time.Parse(time.RFC3339, parts[0]); err == nil {
+				c.Time = t
+				c.Message = parts[1]
+			}
+		}
+
+		timeline = append(timeline, c)
+	}
+
+	sort.Slice(timeline, func(i, j int) bool {
+		return timeline[i].Number < timeline[j].Number
+	})
+
+	return timeline
+}
+
+// AddCheckpoint appends a checkpoint to an ongoing event's timeline without changing its
+// other properties. The current event is read from the API rather than from a previous get,
+// and the update is read back, so that a checkpoint overwritten by a concurrent writer that
+// used the same number is added again under the next one. This narrows the race between
+// writers but does not close it: the API has no conditional update, so a writer that read
+// the event before this checkpoint was verified can still overwrite it afterwards
+func (a *APIClient) AddCheckpoint(ctx context.Context, eventID string, message string, at time.Time) (*Event, error) {
+	for attempt := 0; attempt < checkpointAttempts; attempt++ {
+		event, err := a.GetEvent(ctx, eventID)
+		if err != nil {
+			return nil, err
+		}
+
+		if event.RunningState == StateEnded {
+			return nil, fmt.Errorf("%w: cannot add a checkpoint to an event that has ended", ErrUnexpectedEventState)
+		}
+
+		checkpoint := Checkpoint{Number: 1, Time: at, Message: message}
+		if timeline := event.Timeline(); len(timeline) > 0 {
+			checkpoint.Number = timeline[len(timeline)-1].Number + 1
+		}
+
+		key := fmt.Sprintf("%s%d", checkpointAnnotationPrefix, checkpoint.Number)
+		event.Annotations = MergeAnnotations(event.Annotations, map[string]string{key: checkpoint.String()})
+
+		if err = a.updateExistingEvent(ctx, eventID, event); err != nil {
+			return nil, fmt.Errorf("could not update event: %w", err)
+		}
+
+		// another writer may have added the same checkpoint number at the same time, in
+		// which case only one of the updates survives
+		updated, err := a.GetEvent(ctx, eventID)
+		if err != nil {
+			return nil, err
+		}
+
+		if updated.Annotations[key] == checkpoint.String() {
+			return updated, nil
+		}
+	}
+
+	return nil, fmt.Errorf("could not add checkpoint after %d attempts due to concurrent updates", checkpointAttempts)
+}
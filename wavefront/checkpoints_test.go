@@ -0,0 +1,181 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
+)
+
+func TestAddCheckpoint(t *testing.T) {
+	var mu sync.Mutex
+	event := map[string]interface{}{
+		"id":           "12345",
+		"name":         "Deploy",
+		"runningState": "ONGOING",
+		"annotations": map[string]interface{}{
+			"severity":     "info",
+			"checkpoint-1": "2020-11-01T12:00:00Z migrations done",
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path != "/api/v2/event/12345" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			event = map[string]interface{}{}
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": map[string]interface{}{}, "response": event})
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "checkpoint"}, &http.Client{})
+
+	updated, err := client.AddCheckpoint(context.Background(), "12345", "traffic shifted", time.Date(2020, 11, 1, 12, 5, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	timeline := updated.Timeline()
+
+	if len(timeline) != 2 {
+		t.Fatalf("expected 2 checkpoints, but found %d", len(timeline))
+	}
+
+	if timeline[1].Number != 2 || timeline[1].Message != "traffic shifted" || !timeline[1].Time.Equal(time.Date(2020, 11, 1, 12, 5, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected checkpoint %+v", timeline[1])
+	}
+
+	if updated.Annotations["severity"] != "info" {
+		t.Fatal("expected the other annotations to be preserved, but they were not")
+	}
+}
+
+func TestConcurrentCheckpoints(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		gets, puts    int
+		reads, writes sync.WaitGroup
+	)
+
+	event := map[string]interface{}{"id": "12345", "name": "Deploy", "runningState": "ONGOING", "annotations": map[string]interface{}{}}
+
+	// both writers read the event before either updates it, and both update it before either
+	// reads it back, so the second update overwrites the first writer's checkpoint
+	reads.Add(2)
+	writes.Add(2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var update map[string]interface{}
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		mu.Lock()
+		if r.Method == http.MethodPut {
+			puts++
+			event = update
+		} else {
+			gets++
+		}
+		first := (r.Method == http.MethodPut && puts <= 2) || (r.Method == http.MethodGet && gets <= 2)
+		response := event
+		mu.Unlock()
+
+		if first && r.Method == http.MethodGet {
+			reads.Done()
+			reads.Wait()
+		}
+
+		if first && r.Method == http.MethodPut {
+			writes.Done()
+			writes.Wait()
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": map[string]interface{}{}, "response": response})
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "checkpoint"}, &http.Client{})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, message := range []string{"canary deployed", "traffic shifted"} {
+		wg.Add(1)
+		go func(i int, message string) {
+			defer wg.Done()
+			_, errs[i] = client.AddCheckpoint(context.Background(), "12345", message, time.Date(2020, 11, 1, 12, i, 0, 0, time.UTC))
+		}(i, message)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error occured: %v", err)
+		}
+	}
+
+	final, err := client.GetEvent(context.Background(), "12345")
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	messages := map[string]bool{}
+	for _, c := range final.Timeline() {
+		messages[c.Message] = true
+	}
+
+	if len(final.Timeline()) != 2 || !messages["canary deployed"] || !messages["traffic shifted"] {
+		t.Fatalf("expected both checkpoints in the timeline, but got %+v", final.Timeline())
+	}
+}
+
+func TestEndKeepsCheckpoints(t *testing.T) {
+	server := httptest.NewServer(fake.New(fake.WithToken("checkpoint")))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "checkpoint"}, &http.Client{})
+
+	started, err := client.StartOngoingEvent(ctx, "Deploy", map[string]string{"severity": "info"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if _, err = client.AddCheckpoint(ctx, started.ID, "migrations done", time.Now()); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	ended, err := client.EndOngoingEvent(ctx, started.ID, map[string]string{"severity": "FAILED"})
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(ended.Timeline()) != 1 || ended.Annotations["severity"] != "FAILED" {
+		t.Fatalf("expected the end to keep the checkpoint and update the severity, but got %+v", ended)
+	}
+}
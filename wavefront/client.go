@@ -4,57 +4,317 @@
 package wavefront
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	resource "github.com/vmware-tanzu/observability-event-resource"
 )
 
+// APIClient talks to the Wavefront REST API. Create one with New or NewAPIClient
 type APIClient struct {
-	client  *http.Client
-	baseURL string
-	debug   bool
+	client    *http.Client
+	transport http.RoundTripper
+	baseURL   string
+	retry     RetryPolicy
+
+	requestTimeout time.Duration
+}
+
+// DefaultRequestTimeout limits how long a single attempt at an API request may take, unless
+// the source sets request_timeout
+const DefaultRequestTimeout = 30 * time.Second
+
+// Middleware wraps the transport used to reach the API, for example to log or modify requests
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Option configures an APIClient created by New
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	baseURL        string
+	token          string
+	tokens         TokenSource
+	csp            *CSPCredentials
+	transport      http.RoundTripper
+	middleware     []Middleware
+	retry          RetryPolicy
+	requestTimeout time.Duration
+	userAgent      string
+
+	debug             bool
+	debugOutput       io.Writer
+	secretAnnotations []string
+}
+
+// WithBaseURL sets the URL of the tenant, such as https://longboard.wavefront.com
+func WithBaseURL(baseURL string) Option {
+	return func(o *clientOptions) {
+		o.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithToken sets the API token sent with every request
+func WithToken(token string) Option {
+	return func(o *clientOptions) {
+		o.token = token
+	}
+}
+
+// WithTokenSource sets where the bearer token sent with every request comes from, in place
+// of WithToken and WithCSP
+func WithTokenSource(tokens TokenSource) Option {
+	return func(o *clientOptions) {
+		o.tokens = tokens
+	}
+}
+
+// WithCSP authenticates with access tokens issued by VMware Cloud Services for the credentials,
+// in place of WithToken. Tokens are shared by every client in the process using the same
+// credentials, and are replaced when they are about to expire or the API rejects them
+func WithCSP(credentials CSPCredentials) Option {
+	return func(o *clientOptions) {
+		o.csp = &credentials
+	}
+}
+
+// WithTransport sets the transport requests are sent with. Defaults to http.DefaultTransport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// WithMiddleware wraps the transport with each middleware in turn, so the first one given
+// sees each request first. Requests already carry their authentication headers
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(o *clientOptions) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *clientOptions) {
+		o.retry = policy
+	}
+}
+
+// WithRequestTimeout limits how long a single attempt at a request may take, where 0 disables
+// the limit. Defaults to DefaultRequestTimeout
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.requestTimeout = timeout
+	}
 }
 
-func NewAPIClient(source resource.Source, client *http.Client) *APIClient {
-	if client == nil {
-		client = http.DefaultClient
+// WithUserAgent sets the User-Agent header sent with every request. Defaults to
+// observability-event-resource/<version>
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
 	}
+}
 
-	rt := client.Transport
-	if rt == nil {
-		rt = http.DefaultTransport
+// WithDebug traces every request and response, writing them as JSON lines to stderr, or the
+// writer set by WithDebugOutput. The token and the values of secret annotations are redacted
+func WithDebug(debug bool) Option {
+	return func(o *clientOptions) {
+		o.debug = debug
 	}
+}
 
-	newRT := &AuthRoundTripper{
-		delegate: rt,
-		token:    source.WavefrontToken,
+// WithDebugOutput sets where WithDebug writes its trace
+func WithDebugOutput(w io.Writer) Option {
+	return func(o *clientOptions) {
+		o.debugOutput = w
+	}
+}
+
+// WithSecretAnnotations sets the keys of annotations whose values are redacted from the trace
+func WithSecretAnnotations(keys ...string) Option {
+	return func(o *clientOptions) {
+		o.secretAnnotations = append(o.secretAnnotations, keys...)
+	}
+}
+
+// New creates a client with its own http.Client, so that nothing the caller passes in,
+// such as a transport, is modified
+func New(opts ...Option) *APIClient {
+	o := clientOptions{
+		transport:      http.DefaultTransport,
+		retry:          DefaultRetryPolicy(),
+		requestTimeout: DefaultRequestTimeout,
+		userAgent:      fmt.Sprintf("observability-event-resource/%s", resource.AppVersion),
+		debugOutput:    os.Stderr,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.transport == nil {
+		o.transport = http.DefaultTransport
+	}
+	rt := o.transport
+
+	tokens := o.tokens
+	secrets := []string{o.token}
+	if o.csp != nil {
+		secrets = append(secrets, o.csp.ClientSecret, o.csp.APIToken)
+		if tokens == nil {
+			tokens = NewCSPTokenSource(*o.csp, rt)
+		}
+	}
+
+	if tokens == nil {
+		tokens = staticToken(o.token)
+	}
+
+	// the trace wraps the transport itself, so that it shows requests as they are sent
+	if o.debug {
+		rt = newDebugRoundTripper(rt, o.debugOutput, secrets, o.secretAnnotations)
+	}
+
+	for i := len(o.middleware) - 1; i >= 0; i-- {
+		rt = o.middleware[i](rt)
 	}
 
-	client.Transport = newRT
 	return &APIClient{
-		client:  client,
-		baseURL: strings.TrimSuffix(source.WavefrontURL, "/"),
-		debug:   source.Debug,
+		client: &http.Client{
+			Transport: &AuthRoundTripper{
+				delegate:  rt,
+				tokens:    tokens,
+				userAgent: o.userAgent,
+			},
+		},
+		transport: o.transport,
+		baseURL:   o.baseURL,
+		retry:     o.retry,
+
+		requestTimeout: o.requestTimeout,
 	}
 }
 
-func (a *APIClient) newRequest(method string, uri string, body io.Reader) (*http.Request, error) {
-	return http.NewRequest(method, fmt.Sprintf("%s%s", a.baseURL, uri), body)
+// NewAPIClient creates a client configured by the source, and then by opts. Requests are sent
+// with the transport of client, if it is set, but client itself is not used or modified
+func NewAPIClient(source resource.Source, client *http.Client, opts ...Option) *APIClient {
+	sourceOpts := []Option{
+		WithBaseURL(source.WavefrontURL),
+		WithToken(source.WavefrontToken),
+		WithRetryPolicy(NewRetryPolicy(source.Retry)),
+		WithRequestTimeout(requestTimeout(source.RequestTimeout)),
+		WithDebug(source.Debug),
+		WithSecretAnnotations(source.SecretAnnotations...),
+	}
+
+	if source.UsesCSP() {
+		sourceOpts = append(sourceOpts, WithCSP(CSPCredentials{
+			URL:          source.CSPURL,
+			ClientID:     source.CSPClientID,
+			ClientSecret: source.CSPClientSecret,
+			APIToken:     source.CSPAPIToken,
+		}))
+	}
+
+	var transport http.RoundTripper
+	if client != nil {
+		transport = client.Transport
+	}
+
+	// Source.Validate rejects settings that cannot be applied, so this only fails when a
+	// source is used without being validated. Report it with every request
+	transport, err := ConfigureTransport(source, transport)
+	if err != nil {
+		transport = errorRoundTripper{err: err}
+	}
+
+	sourceOpts = append(sourceOpts, WithTransport(transport))
+
+	return New(append(sourceOpts, opts...)...)
 }
 
+// requestTimeout parses the source's request_timeout, where "0" disables the timeout.
+// Values rejected by resource.Source.Validate are ignored
+func requestTimeout(value string) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d
+	}
+
+	return DefaultRequestTimeout
+}
+
+func (a *APIClient) newRequest(ctx context.Context, method string, uri string, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s", a.baseURL, uri), body)
+}
+
+// AuthRoundTripper adds the bearer token and the headers the API expects to each request.
+// If the API rejects a token that can be refreshed, the request is sent once more with a
+// new token
 type AuthRoundTripper struct {
-	delegate http.RoundTripper
-	token    string
+	delegate  http.RoundTripper
+	tokens    TokenSource
+	userAgent string
 }
 
 func (a *AuthRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.token))
+	token, err := a.tokens.Token(request.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := a.send(request, token)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	// the body can only be sent again if it can be read again
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return response, nil
+	}
+
+	if !a.tokens.Invalidate(token) {
+		return response, nil
+	}
+
+	if token, err = a.tokens.Token(request.Context()); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+
+	retry := request.Clone(request.Context())
+	if request.GetBody != nil {
+		if retry.Body, err = request.GetBody(); err != nil {
+			response.Body.Close()
+			return nil, err
+		}
+	}
+
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+
+	return a.send(retry, token)
+}
+
+func (a *AuthRoundTripper) send(request *http.Request, token string) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	request = request.Clone(request.Context())
+
+	if a.userAgent != "" {
+		request.Header.Set("User-Agent", a.userAgent)
+	}
+
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	request.Header.Add("Accept", "application/json")
-	request.Header.Set("Content-Type", "application/json")
+	if request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
 	return a.delegate.RoundTrip(request)
 }
 
@@ -62,17 +322,8 @@ func (a *AuthRoundTripper) GetDelegate() http.RoundTripper {
 	return a.delegate
 }
 
-type singleItemResponse struct {
-	Status   interface{} `json:"status"`
-	Response interface{} `json:"response"`
-}
-
-type multiItemResponse struct {
-	Status   interface{} `json:"status"`
-	Response struct {
-		Items []interface{} `json:"items"`
-	} `json:"response"`
-}
-
 // ErrBadResponseStatus will be returned when a response code doesn't match the API specification
 var ErrBadResponseStatus = errors.New("invalid response status code")
+
+// ErrUnexpectedEventState will be returned when an event did not reach the requested running state in time
+var ErrUnexpectedEventState = errors.New("event did not reach the expected state")
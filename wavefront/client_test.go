@@ -0,0 +1,166 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+type headerRoundTripper struct {
+	delegate http.RoundTripper
+	headers  http.Header
+}
+
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.headers = req.Header.Clone()
+	return h.delegate.RoundTrip(req)
+}
+
+func TestNewAPIClientDoesNotModifyClient(t *testing.T) {
+	transport := &headerRoundTripper{delegate: http.DefaultTransport}
+	hc := &http.Client{Transport: transport}
+	defaultTransport := http.DefaultClient.Transport
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, httpOKResponse)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "token"}, hc)
+	wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "token"}, nil)
+
+	if hc.Transport != transport {
+		t.Fatalf("expected the client's transport to be left alone, but it was replaced with %T", hc.Transport)
+	}
+
+	if http.DefaultClient.Transport != defaultTransport {
+		t.Fatalf("expected http.DefaultClient to be left alone, but its transport was replaced with %T", http.DefaultClient.Transport)
+	}
+
+	if _, err := client.GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if auth := transport.headers.Get("Authorization"); auth != "Bearer token" {
+		t.Fatalf("expected the request to be sent through the client's transport, but got Authorization %q", auth)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		io.WriteString(w, httpOKResponse)
+	}))
+	defer server.Close()
+
+	var order []string
+	middleware := func(name string) wavefront.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	client := wavefront.New(
+		wavefront.WithBaseURL(server.URL+"/"),
+		wavefront.WithToken("token"),
+		wavefront.WithUserAgent("test-agent/1.0"),
+		wavefront.WithTransport(http.DefaultTransport),
+		wavefront.WithMiddleware(middleware("first"), middleware("second")),
+		wavefront.WithRetryPolicy(wavefront.RetryPolicy{}),
+	)
+
+	if _, err := client.GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if ua := headers.Get("User-Agent"); ua != "test-agent/1.0" {
+		t.Fatalf("expected User-Agent test-agent/1.0, but got %q", ua)
+	}
+
+	if auth := headers.Get("Authorization"); auth != "Bearer token" {
+		t.Fatalf("expected Authorization Bearer token, but got %q", auth)
+	}
+
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Fatalf("expected middleware to run in the order given, but ran %v", order)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDebugTrace(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = string(body)
+
+		io.WriteString(w, `{"status": {}, "response": {"id": "12345", "name": "Deploy", "annotations": {"password": "hunter2", "severity": "info"}}}`)
+	}))
+	defer server.Close()
+
+	var trace bytes.Buffer
+	client := wavefront.NewAPIClient(resource.Source{
+		WavefrontURL:      server.URL,
+		WavefrontToken:    "s3cret",
+		Debug:             true,
+		SecretAnnotations: []string{"password"},
+	}, &http.Client{}, wavefront.WithDebugOutput(&trace))
+
+	if _, err := client.StartOngoingEvent(context.Background(), "Deploy", map[string]string{"password": "hunter2", "severity": "info"}, nil); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if !strings.Contains(received, "hunter2") {
+		t.Fatalf("expected the request to be sent unredacted, but got %s", received)
+	}
+
+	if strings.Contains(trace.String(), "hunter2") || strings.Contains(trace.String(), "s3cret") {
+		t.Fatalf("expected secrets to be redacted from the trace, but got %s", trace.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a request and a response line, but got %d lines", len(lines))
+	}
+
+	var response struct {
+		Type   string
+		Method string
+		Status int
+		Body   struct {
+			Response wavefront.Event
+		}
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &response); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if response.Type != "response" || response.Method != http.MethodPost || response.Status != http.StatusOK {
+		t.Fatalf("unexpected response line %s", lines[1])
+	}
+
+	if response.Body.Response.Annotations["severity"] != "info" || response.Body.Response.Annotations["password"] != "[REDACTED]" {
+		t.Fatalf("expected only the secret annotation to be redacted, but got %v", response.Body.Response.Annotations)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCSPURL is the VMware Cloud Services console that access tokens are requested from
+const DefaultCSPURL = "https://console.cloud.vmware.com"
+
+// cspTokenTimeout limits how long a request for an access token may take
+const cspTokenTimeout = 30 * time.Second
+
+// cspRefreshMargin is how long before an access token expires that it is replaced
+const cspRefreshMargin = time.Minute
+
+// cspDefaultTokenLifetime is how long an access token is used for if CSP does not say when
+// it expires, so that a token is not requested for every API request
+const cspDefaultTokenLifetime = 5 * time.Minute
+
+// TokenSource supplies the bearer token sent with each request
+type TokenSource interface {
+	// Token returns the token to send
+	Token(ctx context.Context) (string, error)
+	// Invalidate discards token after the API rejected it, and reports whether Token may
+	// now return a different one
+	Invalidate(token string) bool
+}
+
+// staticToken is a TokenSource for an API token, which cannot be refreshed
+type staticToken string
+
+func (s staticToken) Token(context.Context) (string, error) {
+	return string(s), nil
+}
+
+func (s staticToken) Invalidate(string) bool {
+	return false
+}
+
+// CSPCredentials authenticate with VMware Cloud Services (CSP). Either the client ID and
+// secret of a server to server app, or a user's API token, must be set
+type CSPCredentials struct {
+	// URL of the CSP console. Defaults to DefaultCSPURL
+	URL          string
+	ClientID     string
+	ClientSecret string
+	APIToken     string
+}
+
+// CSPTokenSource exchanges CSP credentials for access tokens, and keeps using each token
+// until it is about to expire or the API rejects it
+type CSPTokenSource struct {
+	credentials CSPCredentials
+	client      *http.Client
+	now         func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// cspTokenSources holds a token source for each set of credentials used by the process, so
+// that an access token is shared by every client using the same credentials
+var cspTokenSources = struct {
+	sync.Mutex
+	sources map[CSPCredentials]*CSPTokenSource
+}{sources: map[CSPCredentials]*CSPTokenSource{}}
+
+// NewCSPTokenSource returns the process's token source for the credentials, creating it if
+// there is none. Access tokens are requested with the given transport, or
+// http.DefaultTransport if it is nil
+func NewCSPTokenSource(credentials CSPCredentials, transport http.RoundTripper) *CSPTokenSource {
+	credentials.URL = strings.TrimSuffix(credentials.URL, "/")
+	if credentials.URL == "" {
+		credentials.URL = DefaultCSPURL
+	}
+
+	cspTokenSources.Lock()
+	defer cspTokenSources.Unlock()
+
+	if source, ok := cspTokenSources.sources[credentials]; ok {
+		return source
+	}
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	source := &CSPTokenSource{
+		credentials: credentials,
+		client:      &http.Client{Transport: transport, Timeout: cspTokenTimeout},
+		now:         time.Now,
+	}
+
+	cspTokenSources.sources[credentials] = source
+	return source
+}
+
+// Token returns the cached access token, or requests a new one if there is none or it is
+// about to expire
+func (c *CSPTokenSource) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && c.now().Before(c.expiry) {
+		return c.token, nil
+	}
+
+	token, expiresIn, err := c.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if expiresIn <= 0 {
+		expiresIn = cspDefaultTokenLifetime
+	}
+
+	margin := cspRefreshMargin
+	if expiresIn < 2*margin {
+		margin = expiresIn / 2
+	}
+
+	c.token = token
+	c.expiry = c.now().Add(expiresIn - margin)
+	return c.token, nil
+}
+
+// Invalidate discards the cached access token if it is token, so that the next call to
+// Token requests a new one
+func (c *CSPTokenSource) Invalidate(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = ""
+	}
+
+	return true
+}
+
+func (c *CSPTokenSource) requestToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{}
+	uri := c.credentials.URL + "/csp/gateway/am/api/auth/authorize"
+	if c.credentials.APIToken != "" {
+		// CSP calls a user's API token a refresh token, and expects it as one
+		uri = c.credentials.URL + "/csp/gateway/am/api/auth/api-tokens/authorize"
+		form.Set("refresh_token", c.credentials.APIToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.credentials.APIToken == "" {
+		req.SetBasicAuth(c.credentials.ClientID, c.credentials.ClientSecret)
+	}
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrCSPAuthentication, err)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrCSPAuthentication, err)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", 0, fmt.Errorf("%w: expected 2xx, got %d: %s", ErrCSPAuthentication, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err = json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", 0, fmt.Errorf("%w: the response did not include an access token", ErrCSPAuthentication)
+	}
+
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// ErrCSPAuthentication will be returned when CSP does not issue an access token for the credentials
+var ErrCSPAuthentication = errors.New("could not get an access token from VMware Cloud Services")
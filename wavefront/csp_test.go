@@ -0,0 +1,168 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
+)
+
+// newCSPServer returns a CSP server that issues access-1, access-2, and so on, to requests
+// authorized by authorize
+func newCSPServer(t *testing.T, path string, authorize func(*http.Request) bool) (*httptest.Server, *int32) {
+	var issued int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path || r.Method != http.MethodPost || r.ParseForm() != nil || !authorize(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"message": "invalid_grant"}`)
+			return
+		}
+
+		fmt.Fprintf(w, `{"access_token": "access-%d", "token_type": "bearer", "expires_in": 1799}`, atomic.AddInt32(&issued, 1))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &issued
+}
+
+func TestCSPClientCredentials(t *testing.T) {
+	csp, issued := newCSPServer(t, "/csp/gateway/am/api/auth/authorize", func(r *http.Request) bool {
+		id, secret, ok := r.BasicAuth()
+		return ok && id == "client-1" && secret == "s3cret" && r.PostForm.Get("grant_type") == "client_credentials"
+	})
+
+	api := httptest.NewServer(fake.New(fake.WithToken("access-1")))
+	defer api.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{
+		WavefrontURL:    api.URL,
+		CSPURL:          csp.URL,
+		CSPClientID:     "client-1",
+		CSPClientSecret: "s3cret",
+	}, &http.Client{})
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.StartOngoingEvent(context.Background(), "Deploy", nil, nil); err != nil {
+			t.Fatalf("unexpected error occured: %v", err)
+		}
+	}
+
+	if *issued != 1 {
+		t.Fatalf("expected the access token to be cached, but %d were issued", *issued)
+	}
+}
+
+func TestCSPRefreshOnUnauthorized(t *testing.T) {
+	csp, issued := newCSPServer(t, "/csp/gateway/am/api/auth/api-tokens/authorize", func(r *http.Request) bool {
+		_, sentAsAPIToken := r.PostForm["api_token"]
+		return r.PostForm.Get("refresh_token") == "refresh-token" && !sentAsAPIToken
+	})
+
+	// only the second access token issued is accepted, as if the first had been revoked
+	server := fake.New(fake.WithToken("access-2"))
+	api := httptest.NewServer(server)
+	defer api.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{
+		WavefrontURL: api.URL,
+		CSPURL:       csp.URL,
+		CSPAPIToken:  "refresh-token",
+	}, &http.Client{})
+
+	if _, err := client.StartOngoingEvent(context.Background(), "Deploy", map[string]string{"foo": "bar"}, nil); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if *issued != 2 {
+		t.Fatalf("expected a new access token after the first was rejected, but %d were issued", *issued)
+	}
+
+	if events := server.Events(); len(events) != 1 || events[0].Annotations["foo"] != "bar" {
+		t.Fatalf("expected the event to be created once with its body, but got %+v", events)
+	}
+}
+
+func TestCSPTokenWithoutExpiry(t *testing.T) {
+	var issued int32
+	csp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token": "access-%d", "token_type": "bearer"}`, atomic.AddInt32(&issued, 1))
+	}))
+	defer csp.Close()
+
+	api := httptest.NewServer(fake.New(fake.WithToken("access-1")))
+	defer api.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{
+		WavefrontURL:    api.URL,
+		CSPURL:          csp.URL,
+		CSPClientID:     "client-without-expiry",
+		CSPClientSecret: "s3cret",
+	}, &http.Client{})
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.StartOngoingEvent(context.Background(), "Deploy", nil, nil); err != nil {
+			t.Fatalf("unexpected error occured: %v", err)
+		}
+	}
+
+	if issued != 1 {
+		t.Fatalf("expected an access token without an expiry to be cached, but %d were issued", issued)
+	}
+}
+
+func TestCSPRejectedCredentials(t *testing.T) {
+	csp, _ := newCSPServer(t, "/csp/gateway/am/api/auth/authorize", func(r *http.Request) bool {
+		return false
+	})
+
+	api := httptest.NewServer(fake.New())
+	defer api.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{
+		WavefrontURL:    api.URL,
+		CSPURL:          csp.URL,
+		CSPClientID:     "client-1",
+		CSPClientSecret: "wrong",
+	}, &http.Client{})
+
+	_, err := client.GetEvent(context.Background(), "12345")
+	if !errors.Is(err, wavefront.ErrCSPAuthentication) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrCSPAuthentication, err)
+	}
+
+	if wavefront.Hint(err) == "" {
+		t.Fatal("expected a hint, but there was none")
+	}
+}
+
+func TestCSPSourceValidation(t *testing.T) {
+	testCases := []struct {
+		source   resource.Source
+		expected error
+	}{
+		{resource.Source{WavefrontURL: "https://foo", CSPClientID: "id", CSPClientSecret: "secret"}, nil},
+		{resource.Source{WavefrontURL: "https://foo", CSPAPIToken: "token", CSPURL: "https://console.example.com"}, nil},
+		{resource.Source{WavefrontURL: "https://foo", CSPClientID: "id"}, resource.ErrIncompleteCSPCredentials},
+		{resource.Source{WavefrontURL: "https://foo", WavefrontToken: "token", CSPAPIToken: "token"}, resource.ErrConflictingCredentials},
+		{resource.Source{WavefrontURL: "https://foo", CSPAPIToken: "token", CSPURL: "console"}, resource.ErrInvalidCSPURL},
+		{resource.Source{WavefrontURL: "https://foo"}, resource.ErrMissingWavefrontToken},
+	}
+
+	for _, tc := range testCases {
+		err := tc.source.Validate()
+		if (tc.expected == nil && err != nil) || !errors.Is(err, tc.expected) {
+			t.Fatalf("expected to get %v as an error but got %v", tc.expected, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTracedBodyLength is the most of a request or response body a trace line includes
+const maxTracedBodyLength = 64 * 1024
+
+// redacted replaces secrets in trace lines
+const redacted = "[REDACTED]"
+
+// traceLine is one line of a debug trace, written as JSON
+type traceLine struct {
+	Time     string          `json:"time"`
+	Type     string          `json:"type"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Status   int             `json:"status,omitempty"`
+	Duration string          `json:"duration,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// debugRoundTripper writes a trace line for every request, and one for its response or
+// error. Secrets, such as the token, and the values of secret annotations are redacted from them
+type debugRoundTripper struct {
+	delegate http.RoundTripper
+	redactor redactor
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newDebugRoundTripper(delegate http.RoundTripper, out io.Writer, secrets []string, secretAnnotations []string) *debugRoundTripper {
+	r := redactor{annotations: map[string]bool{}}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+
+	for _, key := range secretAnnotations {
+		r.annotations[key] = true
+	}
+
+	return &debugRoundTripper{delegate: delegate, redactor: r, out: out}
+}
+
+func (d *debugRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := d.redactor.redactString(req.URL.String())
+
+	line := traceLine{Type: "request", Method: req.Method, URL: url}
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			// the body is replaced after it is read, and a RoundTripper must not modify its request
+			req = req.Clone(req.Context())
+		}
+
+		body, err := readBody(&req.Body, req.GetBody)
+		if err != nil {
+			return nil, err
+		}
+
+		line.Body = d.redactor.redactBody(body)
+	}
+	d.write(line)
+
+	start := time.Now()
+	response, err := d.delegate.RoundTrip(req)
+	duration := time.Since(start).String()
+
+	if err != nil {
+		d.write(traceLine{Type: "error", Method: req.Method, URL: url, Duration: duration, Error: d.redactor.redactString(err.Error())})
+		return nil, err
+	}
+
+	line = traceLine{Type: "response", Method: req.Method, URL: url, Status: response.StatusCode, Duration: duration}
+	body, err := readBody(&response.Body, nil)
+	if err != nil {
+		d.write(traceLine{Type: "error", Method: req.Method, URL: url, Duration: duration, Error: d.redactor.redactString(err.Error())})
+		return nil, err
+	}
+
+	line.Body = d.redactor.redactBody(body)
+	d.write(line)
+
+	return response, nil
+}
+
+func (d *debugRoundTripper) write(line traceLine) {
+	line.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.out.Write(append(b, '\n'))
+}
+
+// readBody reads the body, and replaces it with an unread copy. If getBody is set, the copy
+// is read from it instead, so that the original body is left as it was
+func readBody(body *io.ReadCloser, getBody func() (io.ReadCloser, error)) ([]byte, error) {
+	if getBody != nil {
+		copied, err := getBody()
+		if err != nil {
+			return nil, err
+		}
+		defer copied.Close()
+
+		return ioutil.ReadAll(copied)
+	}
+
+	b, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+
+	*body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// redactor removes secrets from trace lines
+type redactor struct {
+	// secrets are replaced wherever they appear
+	secrets []string
+	// annotations are the keys of annotations whose values are replaced
+	annotations map[string]bool
+}
+
+func (r redactor) redactString(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+
+	return s
+}
+
+// redactBody returns the body as JSON: as it is, if it is a JSON document, or as a string
+// otherwise, with secrets redacted in either case
+func (r redactor) redactBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var doc interface{}
+	if len(body) <= maxTracedBodyLength && json.Unmarshal(body, &doc) == nil {
+		if b, err := json.Marshal(r.redactJSON(doc)); err == nil {
+			return json.RawMessage(r.redactString(string(b)))
+		}
+	}
+
+	s := string(body)
+	if len(s) > maxTracedBodyLength {
+		s = s[:maxTracedBodyLength] + "..."
+	}
+
+	b, _ := json.Marshal(r.redactString(s))
+	return b
+}
+
+// redactJSON replaces the values of secret annotations anywhere in the document, such as
+// in an event, or in each event of a list
+func (r redactor) redactJSON(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if annotations, ok := value.(map[string]interface{}); ok && key == "annotations" {
+				for k := range annotations {
+					if r.annotations[k] {
+						annotations[k] = redacted
+					}
+				}
+			}
+
+			v[key] = r.redactJSON(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = r.redactJSON(value)
+		}
+	}
+
+	return doc
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodyLength is the most of a response body an APIError includes in its message
+// when the API did not give a status message
+const maxErrorBodyLength = 512
+
+// APIError is returned when the API responds with a status other than 2xx. It wraps
+// ErrBadResponseStatus, and matches the error for its kind of failure, such as
+// ErrUnauthorized or ErrRateLimited, so callers can check for either with errors.Is
+type APIError struct {
+	StatusCode int
+	// Method and Path are those of the request that failed, if known
+	Method string
+	Path   string
+	// Message is the status message the API gave, if the response body included one
+	Message string
+	Body    string
+}
+
+func (e *APIError) Error() string {
+	detail := e.Message
+	if detail == "" {
+		detail = strings.TrimSpace(e.Body)
+		if len(detail) > maxErrorBodyLength {
+			detail = detail[:maxErrorBodyLength] + "..."
+		}
+	}
+
+	msg := fmt.Sprintf("%v: expected 2xx, got %d", ErrBadResponseStatus, e.StatusCode)
+	if kind := e.kind(); kind != nil {
+		msg = fmt.Sprintf("%v (%v)", msg, kind)
+	}
+
+	if detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, detail)
+	}
+
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return ErrBadResponseStatus
+}
+
+// Is reports whether target is the error for this kind of failure
+func (e *APIError) Is(target error) bool {
+	kind := e.kind()
+	return kind != nil && kind == target
+}
+
+// kind returns the error for the kind of failure, or nil if the status code has none
+func (e *APIError) kind() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound && strings.HasPrefix(e.Path, "/api/v2/event/"):
+		return ErrEventNotFound
+	case e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusUnprocessableEntity:
+		return ErrValidation
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500 && e.StatusCode <= 599:
+		return ErrServerError
+	default:
+		return nil
+	}
+}
+
+// badResponseError closes the response, and returns an APIError with its status and body
+func badResponseError(response *http.Response) error {
+	defer response.Body.Close()
+
+	apiErr := &APIError{StatusCode: response.StatusCode}
+	if response.Request != nil {
+		apiErr.Method = response.Request.Method
+		apiErr.Path = response.Request.URL.Path
+	}
+
+	if respBody, err := ioutil.ReadAll(response.Body); err == nil {
+		apiErr.Body = string(respBody)
+
+		var resp struct {
+			Status Status `json:"status"`
+		}
+		if json.Unmarshal(respBody, &resp) == nil {
+			apiErr.Message = resp.Status.Message
+		}
+	}
+
+	return apiErr
+}
+
+// Hint returns advice on fixing the failure behind err, or "" if there is none
+func Hint(err error) string {
+	var preflightErr *PreflightError
+	if errors.As(err, &preflightErr) {
+		return preflightErr.Remediation
+	}
+
+	switch {
+	case errors.Is(err, ErrCSPAuthentication):
+		return "VMware Cloud Services rejected the credentials; check csp_client_id and csp_client_secret, or csp_api_token, and that csp_url is your organization's console"
+	case errors.Is(err, ErrUnauthorized):
+		return "the API token was rejected; check that the source's api_token is a valid, unexpired token for this tenant"
+	case errors.Is(err, ErrForbidden):
+		return "the API token lacks the Events permission; grant it to the user or service account the token belongs to"
+	case errors.Is(err, ErrEventNotFound):
+		return "the event does not exist; it may have been deleted, or the source may point at a different tenant than the one it was created in"
+	case errors.Is(err, ErrValidation):
+		return "Wavefront rejected the request; check the event's name, tags and annotations"
+	case errors.Is(err, ErrRateLimited):
+		return "Wavefront is rate limiting requests; retry later, or allow more time for retries with the source's retry.max_elapsed_time"
+	case errors.Is(err, ErrServerError):
+		return "Wavefront failed to handle the request; retry later, and check the tenant's status if it keeps failing"
+	default:
+		return ""
+	}
+}
+
+// ErrUnauthorized will be returned when the API rejects the token
+var ErrUnauthorized = errors.New("unauthorized: invalid API token")
+
+// ErrForbidden will be returned when the token does not have permission for the request
+var ErrForbidden = errors.New("forbidden: missing permission")
+
+// ErrEventNotFound will be returned when the requested event does not exist
+var ErrEventNotFound = errors.New("event not found")
+
+// ErrValidation will be returned when the API rejects a request as invalid
+var ErrValidation = errors.New("validation error")
+
+// ErrRateLimited will be returned when the API is still rate limiting requests after retrying
+var ErrRateLimited = errors.New("rate limited")
+
+// ErrServerError will be returned when the API still fails with a server error after retrying
+var ErrServerError = errors.New("server error")
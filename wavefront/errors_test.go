@@ -0,0 +1,85 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+func TestAPIErrorKinds(t *testing.T) {
+	testCases := []struct {
+		status   int
+		expected error
+	}{
+		{http.StatusUnauthorized, wavefront.ErrUnauthorized},
+		{http.StatusForbidden, wavefront.ErrForbidden},
+		{http.StatusNotFound, wavefront.ErrEventNotFound},
+		{http.StatusBadRequest, wavefront.ErrValidation},
+		{http.StatusTooManyRequests, wavefront.ErrRateLimited},
+		{http.StatusInternalServerError, wavefront.ErrServerError},
+		{http.StatusServiceUnavailable, wavefront.ErrServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprintf(w, `{"status": {"result": "ERROR", "message": "the message", "code": %d}}`, tc.status)
+			}))
+			defer server.Close()
+
+			client := wavefront.NewAPIClient(resource.Source{
+				WavefrontURL:   server.URL,
+				WavefrontToken: "errors",
+				Retry:          resource.RetryConfig{MaxElapsedTime: "1ms"},
+			}, &http.Client{})
+
+			_, err := client.GetEvent(context.Background(), "12345")
+			if !errors.Is(err, tc.expected) || !errors.Is(err, wavefront.ErrBadResponseStatus) {
+				t.Fatalf("expected to get %v as an error but got %v", tc.expected, err)
+			}
+
+			if !strings.HasSuffix(err.Error(), ": the message") {
+				t.Fatalf("expected the error to end with the API's message, but it was %q", err.Error())
+			}
+
+			if wavefront.Hint(err) == "" {
+				t.Fatal("expected a hint, but there was none")
+			}
+		})
+	}
+}
+
+func TestAPIErrorWithoutMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<html>not found</html>")
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "errors"}, &http.Client{})
+
+	_, err := client.SearchEvents(context.Background(), wavefront.EventSearch{})
+	if errors.Is(err, wavefront.ErrEventNotFound) || !errors.Is(err, wavefront.ErrBadResponseStatus) {
+		t.Fatalf("expected only %v as an error, but got %v", wavefront.ErrBadResponseStatus, err)
+	}
+
+	if !strings.Contains(err.Error(), "<html>not found</html>") {
+		t.Fatalf("expected the error to include the body, but it was %q", err.Error())
+	}
+
+	if hint := wavefront.Hint(err); hint != "" {
+		t.Fatalf("expected no hint, but got %q", hint)
+	}
+}
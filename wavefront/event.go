@@ -0,0 +1,199 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Running states of an event
+const (
+	StatePending = "PENDING"
+	StateOngoing = "ONGOING"
+	StateEnded   = "ENDED"
+)
+
+// Types of creator an event can have
+const (
+	CreatorUser   = "USER"
+	CreatorAlert  = "ALERT"
+	CreatorSystem = "SYSTEM"
+)
+
+// Event is an event as represented by the API. Times are in milliseconds since the epoch,
+// and are 0 when not set. Fields the API returns that are not modelled here are kept in
+// Extra, so that sending an event back in an update does not drop them
+type Event struct {
+	ID                 string            `json:"id,omitempty"`
+	Name               string            `json:"name"`
+	StartTime          int64             `json:"startTime,omitempty"`
+	EndTime            int64             `json:"endTime,omitempty"`
+	Annotations        map[string]string `json:"annotations"`
+	Tags               []string          `json:"tags,omitempty"`
+	Hosts              []string          `json:"hosts,omitempty"`
+	RunningState       string            `json:"runningState,omitempty"`
+	CreatorID          string            `json:"creatorId,omitempty"`
+	CreatorType        []string          `json:"creatorType,omitempty"`
+	UpdaterID          string            `json:"updaterId,omitempty"`
+	CreatedEpochMillis int64             `json:"createdEpochMillis,omitempty"`
+	UpdatedEpochMillis int64             `json:"updatedEpochMillis,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// eventFields has the same fields as Event, without its JSON methods
+type eventFields Event
+
+// eventFieldNames are the JSON names of the fields modelled by Event
+var eventFieldNames = func() []string {
+	var names []string
+
+	t := reflect.TypeOf(eventFields{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}()
+
+// UnmarshalJSON decodes an event, keeping any fields that are not modelled in Extra
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields eventFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+
+	for _, name := range eventFieldNames {
+		delete(extra, name)
+	}
+
+	if len(extra) > 0 {
+		fields.Extra = extra
+	}
+
+	*e = Event(fields)
+	return nil
+}
+
+// MarshalJSON encodes an event, including the fields in Extra. Annotations are omitted
+// when they are nil, but an empty map is kept, since the API requires them on new events
+func (e Event) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(eventFields(e))
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	if err = json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	if e.Annotations == nil {
+		delete(all, "annotations")
+	}
+
+	for k, v := range e.Extra {
+		if _, ok := all[k]; !ok {
+			all[k] = v
+		}
+	}
+
+	return json.Marshal(all)
+}
+
+// Start returns the event's start time, or the zero time if it is not set
+func (e Event) Start() time.Time {
+	return fromMillis(e.StartTime)
+}
+
+// End returns the event's end time, or the zero time if it is not set, for example
+// because the event is still ongoing
+func (e Event) End() time.Time {
+	return fromMillis(e.EndTime)
+}
+
+// Status is the status object the API includes in each response
+type Status struct {
+	Result  string `json:"result,omitempty"`
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+// EventResponse is the API's response to a request for a single event
+type EventResponse struct {
+	Status   Status `json:"status"`
+	Response *Event `json:"response"`
+}
+
+// EventPage is one page of a list of events
+type EventPage struct {
+	Items     []Event `json:"items"`
+	Cursor    string  `json:"cursor,omitempty"`
+	Offset    int     `json:"offset,omitempty"`
+	Limit     int     `json:"limit,omitempty"`
+	MoreItems bool    `json:"moreItems"`
+}
+
+// EventListResponse is the API's response to a request for a list of events
+type EventListResponse struct {
+	Status   Status    `json:"status"`
+	Response EventPage `json:"response"`
+}
+
+// MergeAnnotations returns existing with updates applied. An update with an empty value
+// removes that annotation
+func MergeAnnotations(existing map[string]string, updates map[string]string) map[string]string {
+	merged := make(map[string]string, len(existing)+len(updates))
+	for k, v := range existing {
+		merged[k] = v
+	}
+
+	for k, v := range updates {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+
+		merged[k] = v
+	}
+
+	return merged
+}
+
+func annotationsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(millis int64) time.Time {
+	if millis <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
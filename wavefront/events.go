@@ -5,24 +5,29 @@ package wavefront
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
-	"github.com/mitchellh/pointerstructure"
-	resource "github.com/vmware-tanzu/observability-event-resource"
 )
 
-func (a *APIClient) GetEventJSON(eventID string) ([]byte, error) {
+// listPageSize is the number of events requested per page when listing events
+const listPageSize = 100
+
+// GetEvent returns the event with the given ID
+func (a *APIClient) GetEvent(ctx context.Context, eventID string) (*Event, error) {
 	uri := fmt.Sprintf("/api/v2/event/%s", url.PathEscape(eventID))
 
-	req, err := a.newRequest(http.MethodGet, uri, nil)
+	req, err := a.newRequest(ctx, http.MethodGet, uri, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -30,234 +35,433 @@ func (a *APIClient) GetEventJSON(eventID string) ([]byte, error) {
 	return a.doEventRequest(req)
 }
 
-func (a *APIClient) CreateInstantEvent(name string, annotations map[string]string, tags []string) ([]byte, error) {
-	start := time.Now().UnixNano() / int64(time.Millisecond)
-	end := start + 1
+// ListEvents returns every event that started within the given time range, reading
+// each page of results from the API until there are no more
+func (a *APIClient) ListEvents(ctx context.Context, earliest time.Time, latest time.Time) ([]Event, error) {
+	return a.IterateEvents(ctx, earliest, latest).All()
+}
+
+// IterateEvents returns an iterator over the events that started within the given time range
+func (a *APIClient) IterateEvents(ctx context.Context, earliest time.Time, latest time.Time) *EventIterator {
+	return newEventIterator(cursorFetcher(func(cursor string) (EventPage, error) {
+		query := url.Values{}
+		query.Set("earliestStartTimeEpochMillis", strconv.FormatInt(toMillis(earliest), 10))
+		query.Set("latestStartTimeEpochMillis", strconv.FormatInt(toMillis(latest), 10))
+		query.Set("limit", strconv.Itoa(listPageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
 
-	return a.createEvent(name, annotations, tags, start, end)
+		req, err := a.newRequest(ctx, http.MethodGet, "/api/v2/event?"+query.Encode(), nil)
+		if err != nil {
+			return EventPage{}, err
+		}
+
+		return a.doListRequest(req)
+	}))
 }
 
-func (a *APIClient) StartOngoingEvent(name string, annotations map[string]string, tags []string) ([]byte, error) {
-	return a.createEvent(name, annotations, tags, 0, 0)
+// SearchCondition is one term of an event search query
+type SearchCondition struct {
+	Key            string `json:"key"`
+	Value          string `json:"value"`
+	MatchingMethod string `json:"matchingMethod,omitempty"`
+	Negated        bool   `json:"negated,omitempty"`
 }
 
-func (a *APIClient) createEvent(name string, annotations map[string]string, tags []string, startTimeMillis int64, endTimeMillis int64) ([]byte, error) {
-	requestBody := map[string]interface{}{
-		"name":        name,
-		"annotations": annotations,
-		"tags":        tags,
-	}
+// EventSearch is a query for the event search API. Events must satisfy every condition
+// and, if set, have started within the time range
+type EventSearch struct {
+	Query    []SearchCondition
+	Earliest time.Time
+	Latest   time.Time
+}
 
-	if startTimeMillis > 0 {
-		requestBody["startTime"] = startTimeMillis
-	}
+// SearchRequest is the body of a request to the event search API
+type SearchRequest struct {
+	Query     []SearchCondition `json:"query"`
+	Limit     int               `json:"limit"`
+	Offset    int               `json:"offset"`
+	TimeRange *SearchTimeRange  `json:"timeRange,omitempty"`
+}
+
+// SearchTimeRange limits a search to events that started within it
+type SearchTimeRange struct {
+	EarliestStartTimeEpochMillis int64 `json:"earliestStartTimeEpochMillis"`
+	LatestStartTimeEpochMillis   int64 `json:"latestStartTimeEpochMillis"`
+}
+
+// SearchEvents returns every event matching the search, reading each page of results
+// from the API until there are no more
+func (a *APIClient) SearchEvents(ctx context.Context, search EventSearch) ([]Event, error) {
+	return a.IterateSearch(ctx, search).All()
+}
 
-	if endTimeMillis > 0 {
-		requestBody["endTime"] = endTimeMillis
+// IterateSearch returns an iterator over the events matching the search
+func (a *APIClient) IterateSearch(ctx context.Context, search EventSearch) *EventIterator {
+	body := SearchRequest{
+		Query: search.Query,
+		Limit: listPageSize,
 	}
 
-	bodyBytes, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
+	if body.Query == nil {
+		body.Query = []SearchCondition{}
 	}
 
-	req, err := a.newRequest(http.MethodPost, "/api/v2/event", bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return nil, err
+	if !search.Earliest.IsZero() || !search.Latest.IsZero() {
+		latest := search.Latest
+		if latest.IsZero() {
+			latest = time.Now()
+		}
+
+		body.TimeRange = &SearchTimeRange{
+			EarliestStartTimeEpochMillis: toMillis(search.Earliest),
+			LatestStartTimeEpochMillis:   toMillis(latest),
+		}
 	}
 
-	return a.doEventRequest(req)
-}
+	return newEventIterator(func() (EventPage, error) {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return EventPage{}, fmt.Errorf("could not serialize to json: %w", err)
+		}
 
-func (a *APIClient) EndOngoingEvent(eventID string, eventJSON []byte, newAnnotations map[string]string) ([]byte, error) {
-	if eventJSON != nil && newAnnotations != nil {
-		var event interface{}
+		req, err := a.newRequest(ctx, http.MethodPost, "/api/v2/search/event", bytes.NewBuffer(bodyBytes))
+		if err != nil {
+			return EventPage{}, err
+		}
 
-		err := json.NewDecoder(bytes.NewBuffer(eventJSON)).Decode(&event)
+		page, err := a.doListRequest(req)
 		if err != nil {
-			return nil, fmt.Errorf("could not parse event json: %w", err)
+			return EventPage{}, err
+		}
+
+		body.Offset += len(page.Items)
+		return page, nil
+	})
+}
+
+// AlertFirings returns the events created by alerts firing while the given event was ongoing
+func (a *APIClient) AlertFirings(ctx context.Context, eventID string) ([]Event, error) {
+	return a.IterateAlertFirings(ctx, eventID).All()
+}
+
+// IterateAlertFirings returns an iterator over the events created by alerts firing while
+// the given event was ongoing
+func (a *APIClient) IterateAlertFirings(ctx context.Context, eventID string) *EventIterator {
+	it := newEventIterator(cursorFetcher(func(cursor string) (EventPage, error) {
+		query := url.Values{}
+		query.Set("isOverlapped", "true")
+		query.Set("limit", strconv.Itoa(listPageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
 		}
 
-		newEvent, err := mergeAnnotationsIntoEvent(event, newAnnotations)
+		uri := fmt.Sprintf("/api/v2/event/%s/events?%s", url.PathEscape(eventID), query.Encode())
+		req, err := a.newRequest(ctx, http.MethodGet, uri, nil)
 		if err != nil {
-			return nil, fmt.Errorf("error merging new annotations with existing annotations: %w", err)
+			return EventPage{}, err
 		}
 
-		if newEvent != nil { // newEvent will be nil if no changes were made, so if it's not nil, we need to update
-			if err = a.updateExistingEvent(eventID, newEvent); err != nil {
-				return nil, fmt.Errorf("could not update event: %w", err)
+		return a.doListRequest(req)
+	}))
+
+	it.keep = func(event Event) bool {
+		for _, creator := range event.CreatorType {
+			if strings.EqualFold(creator, CreatorAlert) {
+				return true
 			}
 		}
-	}
 
-	req, err := a.newRequest(http.MethodPost, fmt.Sprintf("/api/v2/event/%s/close", url.PathEscape(eventID)), nil)
-	if err != nil {
-		return nil, err
+		return false
 	}
 
-	return a.doEventRequest(req)
+	return it
 }
 
-func (a *APIClient) updateExistingEvent(eventID string, event interface{}) error {
-	bodyBytes, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("could not serialize to json: %w", err)
-	}
+// ParentEventAnnotation records the ID of an event's parent
+const ParentEventAnnotation = "parent-event-id"
 
-	req, err := a.newRequest(http.MethodPut, fmt.Sprintf("/api/v2/event/%s", url.PathEscape(eventID)), bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return fmt.Errorf("error generating HTTP request: %w", err)
-	}
+// AnnotationSearchKey returns the search key that matches the value of the given annotation
+func AnnotationSearchKey(annotation string) string {
+	return "annotations." + annotation
+}
 
-	_, err = a.doEventRequest(req)
-	return err
+// FindChildEvents returns the events started since the given time whose ParentEventAnnotation
+// is parentID. The search API matches the annotation, so that only the children are read
+func (a *APIClient) FindChildEvents(ctx context.Context, parentID string, since time.Time) ([]Event, error) {
+	return a.SearchEvents(ctx, EventSearch{
+		Query: []SearchCondition{
+			{Key: AnnotationSearchKey(ParentEventAnnotation), Value: parentID, MatchingMethod: "EXACT"},
+		},
+		Earliest: since,
+	})
 }
 
-func (a *APIClient) doEventRequest(req *http.Request) ([]byte, error) {
+// WaitForEventState polls the given event until its running state matches state, or until
+// timeout has elapsed or ctx is done. The event from the final poll is returned
+func (a *APIClient) WaitForEventState(ctx context.Context, eventID string, state string, timeout time.Duration) (*Event, error) {
 	var (
-		response *http.Response
-		err      error
+		event    *Event
+		stateErr error
 	)
 
 	operation := backoff.Operation(func() error {
-		response, err = a.client.Do(req)
-		if err != nil {
+		var err error
+		if event, err = a.GetEvent(ctx, eventID); err != nil {
 			return backoff.Permanent(err)
 		}
 
-		if response.StatusCode != http.StatusOK {
-			if response.StatusCode == http.StatusNotAcceptable {
-				return errors.New("retry")
-			}
-
-			return backoff.Permanent(fmt.Errorf("%w: expected 200, got %d", ErrBadResponseStatus, response.StatusCode))
+		if !strings.EqualFold(event.RunningState, state) {
+			stateErr = fmt.Errorf("%w: expected %s, but it was %s", ErrUnexpectedEventState, state, event.RunningState)
+			return stateErr
 		}
 
 		return nil
 	})
 
 	exp := backoff.NewExponentialBackOff()
-	exp.MaxElapsedTime = 2 * time.Minute
+	exp.MaxInterval = 30 * time.Second
+	exp.MaxElapsedTime = timeout
 
-	err = backoff.Retry(operation, exp)
-	if err != nil {
-		if response != nil && response.Body != nil {
-			defer response.Body.Close()
-			if respBody, readErr := ioutil.ReadAll(response.Body); readErr == nil {
-				err = fmt.Errorf("%w: %s", err, string(respBody))
-			}
+	if err := backoff.Retry(operation, backoff.WithContext(exp, ctx)); err != nil {
+		// when ctx ends the wait, report the state the event was left in
+		if ctx.Err() != nil && stateErr != nil {
+			return nil, fmt.Errorf("%w (%v)", stateErr, ctx.Err())
 		}
 
 		return nil, err
 	}
-	defer response.Body.Close()
 
-	var resp singleItemResponse
-	if err = json.NewDecoder(response.Body).Decode(&resp); err != nil {
-		return nil, err
+	return event, nil
+}
+
+// CreateInstantEvent creates an event that starts and ends now
+func (a *APIClient) CreateInstantEvent(ctx context.Context, name string, annotations map[string]string, tags []string) (*Event, error) {
+	start := toMillis(time.Now())
+	end := start + 1
+
+	return a.createEvent(ctx, name, annotations, tags, start, end)
+}
+
+// StartOngoingEvent creates an event that starts now and is ONGOING until it is closed
+func (a *APIClient) StartOngoingEvent(ctx context.Context, name string, annotations map[string]string, tags []string) (*Event, error) {
+	return a.createEvent(ctx, name, annotations, tags, 0, 0)
+}
+
+// CreateEvent creates an event that starts at start and ends at end, or that is ONGOING until
+// it is closed if end is the zero time. It records events after the fact, such as when
+// replaying them
+func (a *APIClient) CreateEvent(ctx context.Context, name string, annotations map[string]string, tags []string, start time.Time, end time.Time) (*Event, error) {
+	var endMillis int64
+	if !end.IsZero() {
+		endMillis = toMillis(end)
+		if endMillis <= toMillis(start) {
+			endMillis = toMillis(start) + 1
+		}
 	}
 
-	return json.Marshal(resp.Response)
+	return a.createEvent(ctx, name, annotations, tags, toMillis(start), endMillis)
 }
 
-// GetEventID simply returns the ID of the event as specified in an event JSON block
-func GetEventID(event interface{}) (string, error) {
-	return getStr(event, "/id")
+func (a *APIClient) createEvent(ctx context.Context, name string, annotations map[string]string, tags []string, startTimeMillis int64, endTimeMillis int64) (*Event, error) {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	event := Event{
+		Name:        name,
+		Annotations: annotations,
+		Tags:        tags,
+		StartTime:   startTimeMillis,
+		EndTime:     endTimeMillis,
+	}
+
+	bodyBytes, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := a.newRequest(ctx, http.MethodPost, "/api/v2/event", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return a.doEventRequest(req)
 }
 
-// GetConcourseMetadata will return the following key-value pairs:
-//
-//		key: name, value: <event name>
-//		key: state, value: <event state>
-func GetConcourseMetadata(event interface{}) (resource.Metadata, error) {
-	name, err := getStr(event, "/name")
+// EndOngoingEvent closes the event. If newAnnotations is set, they are first merged into the
+// event's current annotations as by MergeAnnotations, and the event is updated if they
+// changed. The event is read from the API rather than from a previous get, so that changes
+// made since then, such as checkpoints, are kept
+func (a *APIClient) EndOngoingEvent(ctx context.Context, eventID string, newAnnotations map[string]string) (*Event, error) {
+	if newAnnotations != nil {
+		event, err := a.GetEvent(ctx, eventID)
+		if err != nil {
+			return nil, fmt.Errorf("could not read event: %w", err)
+		}
+
+		merged := MergeAnnotations(event.Annotations, newAnnotations)
+		if !annotationsEqual(merged, event.Annotations) {
+			updated := *event
+			updated.Annotations = merged
+
+			if err := a.updateExistingEvent(ctx, eventID, &updated); err != nil {
+				return nil, fmt.Errorf("could not update event: %w", err)
+			}
+		}
+	}
+
+	req, err := a.newRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v2/event/%s/close", url.PathEscape(eventID)), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	state, err := getStr(event, "/runningState")
+	return a.doEventRequest(req)
+}
+
+// EndEventAt ends the event as EndOngoingEvent does, then moves its end time back to end. The
+// close endpoint always ends an event now and cannot be given a time, so the end time of an
+// event closed later than it ended, such as by a replayed put, can only be set by updating it
+func (a *APIClient) EndEventAt(ctx context.Context, eventID string, end time.Time, newAnnotations map[string]string) (*Event, error) {
+	event, err := a.EndOngoingEvent(ctx, eventID, newAnnotations)
 	if err != nil {
 		return nil, err
 	}
 
-	return resource.Metadata{
-		resource.Metadatum{
-			Name:  "name",
-			Value: name,
-		},
-		resource.Metadatum{
-			Name:  "state",
-			Value: state,
-		},
-	}, nil
+	endTime := toMillis(end)
+	if endTime <= event.StartTime {
+		endTime = event.StartTime + 1
+	}
+
+	if event.EndTime != 0 && event.EndTime <= endTime {
+		return event, nil
+	}
+
+	updated := *event
+	updated.EndTime = endTime
+	if err = a.updateExistingEvent(ctx, eventID, &updated); err != nil {
+		return nil, fmt.Errorf("could not set end time: %w", err)
+	}
+
+	return &updated, nil
 }
 
-func getStr(event interface{}, query string) (string, error) {
-	obj, err := pointerstructure.Get(event, query)
+func (a *APIClient) updateExistingEvent(ctx context.Context, eventID string, event *Event) error {
+	bodyBytes, err := json.Marshal(event)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("could not serialize to json: %w", err)
 	}
 
-	str, ok := obj.(string)
-	if !ok {
-		return "", fmt.Errorf("expected %s to be a string, but it was %T", query, obj)
+	req, err := a.newRequest(ctx, http.MethodPut, fmt.Sprintf("/api/v2/event/%s", url.PathEscape(eventID)), bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return fmt.Errorf("error generating HTTP request: %w", err)
 	}
 
-	return str, nil
+	_, err = a.doEventRequest(req)
+	return err
 }
 
-func mergeAnnotationsIntoEvent(event interface{}, newAnnotations map[string]string) (interface{}, error) {
-	var (
-		existingAnnotations interface{}
-		err                 error
-	)
+// DeleteEvent deletes the event
+func (a *APIClient) DeleteEvent(ctx context.Context, eventID string) error {
+	req, err := a.newRequest(ctx, http.MethodDelete, fmt.Sprintf("/api/v2/event/%s", url.PathEscape(eventID)), nil)
+	if err != nil {
+		return err
+	}
+
+	return a.doStatusRequest(req)
+}
+
+// AddTag adds a tag to the event. Adding a tag the event already has is not an error
+func (a *APIClient) AddTag(ctx context.Context, eventID string, tag string) error {
+	return a.tagRequest(ctx, http.MethodPut, eventID, tag)
+}
+
+// RemoveTag removes a tag from the event
+func (a *APIClient) RemoveTag(ctx context.Context, eventID string, tag string) error {
+	return a.tagRequest(ctx, http.MethodDelete, eventID, tag)
+}
 
-	if existingAnnotations, err = pointerstructure.Get(event, "/response/annotations"); err != nil {
-		return nil, fmt.Errorf("could not retrieve required annotations field: %w", err)
+// SetTags replaces the event's tags
+func (a *APIClient) SetTags(ctx context.Context, eventID string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
 	}
 
-	finalMap := map[string]interface{}{}
-	existingAnnotationMap, ok := existingAnnotations.(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("expected existing annotations to be map[string]interface{} but it was %T", existingAnnotations)
+	bodyBytes, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("could not serialize to json: %w", err)
 	}
 
-	for k, v := range existingAnnotationMap {
-		finalMap[k] = v
+	req, err := a.newRequest(ctx, http.MethodPost, fmt.Sprintf("/api/v2/event/%s/tag", url.PathEscape(eventID)), bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return err
 	}
 
-	for k, v := range newAnnotations {
-		if v == "" {
-			if _, ok = finalMap[k]; ok {
-				str, ok := finalMap[k].(string)
-				if !ok {
-					return nil, fmt.Errorf("expected value at %s to be a string, but it was %T", k, v)
-				}
-				if str != "" {
-					// if the existing annotation is not "" and the new one is, delete the key from finalMap
-					delete(finalMap, k)
-					continue
-				}
-			}
+	return a.doStatusRequest(req)
+}
 
-			continue
-		}
+func (a *APIClient) tagRequest(ctx context.Context, method string, eventID string, tag string) error {
+	if tag == "" {
+		return ErrEmptyTag
+	}
 
-		finalMap[k] = v
+	uri := fmt.Sprintf("/api/v2/event/%s/tag/%s", url.PathEscape(eventID), url.PathEscape(tag))
+	req, err := a.newRequest(ctx, method, uri, nil)
+	if err != nil {
+		return err
 	}
 
-	if !reflect.DeepEqual(existingAnnotations, finalMap) {
-		if event, err = pointerstructure.Set(event, "/response/annotations", newAnnotations); err != nil {
-			return nil, fmt.Errorf("could not modify annotations: %w", err)
-		}
+	return a.doStatusRequest(req)
+}
 
-		newEvent, err := pointerstructure.Get(event, "/response")
-		if err != nil {
-			return nil, fmt.Errorf("could not get response JSON: %w", err)
-		}
+// doEventRequest sends the request and returns the event in the response
+func (a *APIClient) doEventRequest(req *http.Request) (*Event, error) {
+	response, err := a.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
 
-		return newEvent, nil
+	var resp EventResponse
+	if err = json.NewDecoder(response.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("could not parse event: %w", err)
 	}
 
-	return nil, nil
+	if resp.Response == nil {
+		return nil, errors.New("the response did not include an event")
+	}
+
+	return resp.Response, nil
 }
+
+// doListRequest sends the request and returns the page of events in the response
+func (a *APIClient) doListRequest(req *http.Request) (EventPage, error) {
+	response, err := a.doRequest(req)
+	if err != nil {
+		return EventPage{}, err
+	}
+	defer response.Body.Close()
+
+	var resp EventListResponse
+	if err = json.NewDecoder(response.Body).Decode(&resp); err != nil {
+		return EventPage{}, fmt.Errorf("could not parse event list: %w", err)
+	}
+
+	return resp.Response, nil
+}
+
+// doStatusRequest sends a request whose response has no content other than its status
+func (a *APIClient) doStatusRequest(req *http.Request) error {
+	response, err := a.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	return err
+}
+
+// ErrEmptyTag will be returned when adding or removing a tag with no name
+var ErrEmptyTag = errors.New("tag must not be empty")
@@ -1,13 +1,20 @@
 package wavefront_test
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	resource "github.com/vmware-tanzu/observability-event-resource"
 	"github.com/vmware-tanzu/observability-event-resource/wavefront"
@@ -35,7 +42,7 @@ func TestRetryLogic(t *testing.T) {
 	}
 
 	client := wavefront.NewAPIClient(source, hc)
-	_, err := client.StartOngoingEvent("My event", map[string]string{"foo": "bar"}, []string{"tag1", "tag2"})
+	_, err := client.StartOngoingEvent(context.Background(), "My event", map[string]string{"foo": "bar"}, []string{"tag1", "tag2"})
 	if err != nil {
 		t.Fatalf("unexpected error occured: %v", err)
 	}
@@ -43,10 +50,356 @@ func TestRetryLogic(t *testing.T) {
 	if handler.retryCount != 0 {
 		t.Fatalf("expected the client to retry twice, but retried %d times", 2-handler.retryCount)
 	}
+
+	if len(handler.bodies) != 3 {
+		t.Fatalf("expected 3 requests, but got %d", len(handler.bodies))
+	}
+
+	for i, body := range handler.bodies {
+		if !strings.Contains(body, `"name":"My event"`) {
+			t.Fatalf("expected attempt %d to send the event, but sent %q", i+1, body)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	handler := &testServerHandler{
+		retryCount:  1,
+		retryStatus: http.StatusTooManyRequests,
+		retryAfter:  "1",
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	source := resource.Source{
+		WavefrontURL:   server.URL,
+		WavefrontToken: "retry",
+		Retry:          resource.RetryConfig{InitialInterval: "1ms"},
+	}
+
+	start := time.Now()
+	client := wavefront.NewAPIClient(source, &http.Client{})
+	if _, err := client.CreateInstantEvent(context.Background(), "My event", nil, nil); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected the client to wait for the Retry-After header, but retried after %s", elapsed)
+	}
+
+	if len(handler.bodies) != 2 {
+		t.Fatalf("expected 2 requests, but got %d", len(handler.bodies))
+	}
+}
+
+func TestRetryableStatusCodes(t *testing.T) {
+	testCases := []struct {
+		name      string
+		status    int
+		config    resource.RetryConfig
+		expectErr bool
+		requests  int
+	}{
+		{"server errors are retried", http.StatusBadGateway, resource.RetryConfig{}, false, 3},
+		{"client errors are not retried", http.StatusBadRequest, resource.RetryConfig{}, true, 1},
+		{"configured codes are retried", http.StatusConflict, resource.RetryConfig{RetryableStatusCodes: []int{409}}, false, 3},
+		{"configured codes replace the defaults", http.StatusNotAcceptable, resource.RetryConfig{RetryableStatusCodes: []int{409}}, true, 1},
+		{"retries stop after the max elapsed time", http.StatusServiceUnavailable, resource.RetryConfig{MaxElapsedTime: "1ms"}, true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &testServerHandler{retryCount: 2, retryStatus: tc.status}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			tc.config.InitialInterval = "1ms"
+			if tc.config.MaxElapsedTime == "1ms" {
+				tc.config.InitialInterval = "10ms"
+			}
+
+			source := resource.Source{WavefrontURL: server.URL, WavefrontToken: "retry", Retry: tc.config}
+			if err := source.Validate(); err != nil {
+				t.Fatalf("unexpected error occured: %v", err)
+			}
+
+			client := wavefront.NewAPIClient(source, &http.Client{})
+			_, err := client.StartOngoingEvent(context.Background(), "My event", nil, nil)
+			if tc.expectErr && !errors.Is(err, wavefront.ErrBadResponseStatus) {
+				t.Fatalf("expected a bad response status, but got %v", err)
+			}
+
+			if !tc.expectErr && err != nil {
+				t.Fatalf("unexpected error occured: %v", err)
+			}
+
+			if len(handler.bodies) != tc.requests {
+				t.Fatalf("expected %d requests, but got %d", tc.requests, len(handler.bodies))
+			}
+		})
+	}
+}
+
+func TestInvalidRetryConfig(t *testing.T) {
+	for _, config := range []resource.RetryConfig{
+		{MaxElapsedTime: "soon"},
+		{MaxElapsedTime: "-1s"},
+		{InitialInterval: "-1s"},
+		{InitialInterval: "0s"},
+		{RetryableStatusCodes: []int{200}},
+	} {
+		source := resource.Source{WavefrontURL: "https://example.com", WavefrontToken: "token", Retry: config}
+		if err := source.Validate(); !errors.Is(err, resource.ErrInvalidRetryConfig) {
+			t.Fatalf("expected %+v to be invalid, but got %v", config, err)
+		}
+	}
+
+	// retrying until the request succeeds is allowed
+	source := resource.Source{WavefrontURL: "https://example.com", WavefrontToken: "token", Retry: resource.RetryConfig{MaxElapsedTime: "0"}}
+	if err := source.Validate(); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+
+		io.WriteString(w, httpOKResponse)
+	}))
+	defer server.Close()
+
+	source := resource.Source{
+		WavefrontURL:   server.URL,
+		WavefrontToken: "timeout",
+		RequestTimeout: "50ms",
+		Retry:          resource.RetryConfig{InitialInterval: "1ms"},
+	}
+
+	client := wavefront.NewAPIClient(source, &http.Client{})
+	if _, err := client.GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if requests != 2 {
+		t.Fatalf("expected the timed out request to be retried once, but got %d requests", requests)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	handler := &testServerHandler{retryCount: 100}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "cancel"}, &http.Client{})
+	_, err := client.StartOngoingEvent(ctx, "My event", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to get %v as an error but got %v", context.DeadlineExceeded, err)
+	}
+
+	if handler.retryCount < 95 {
+		t.Fatalf("expected retries to stop when the context was done, but retried %d times", 100-handler.retryCount)
+	}
+}
+
+func TestListEventsPagination(t *testing.T) {
+	pages := map[string]string{
+		"":  `{"status": {}, "response": {"moreItems": true, "cursor": "b", "items": [{"id": "a"}, {"id": "b"}]}}`,
+		"b": `{"status": {}, "response": {"moreItems": true, "items": [{"id": "c"}]}}`,
+		"c": `{"status": {}, "response": {"moreItems": false, "items": [{"id": "d"}]}}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("earliestStartTimeEpochMillis") != "1604232000000" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		page, ok := pages[r.URL.Query().Get("cursor")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		io.WriteString(w, page)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "list"}, &http.Client{})
+
+	start := time.Unix(1604232000, 0)
+	events, err := client.ListEvents(context.Background(), start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events across all pages, but got %d", len(events))
+	}
+}
+
+func TestSearchEventsPagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var search wavefront.SearchRequest
+		if err := json.NewDecoder(r.Body).Decode(&search); err != nil || len(search.Query) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if search.Offset == 0 {
+			io.WriteString(w, `{"status": {}, "response": {"moreItems": true, "items": [{"id": "a"}, {"id": "b"}]}}`)
+			return
+		}
+
+		io.WriteString(w, `{"status": {}, "response": {"moreItems": false, "items": [{"id": "c"}]}}`)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "search"}, &http.Client{})
+
+	it := client.IterateSearch(context.Background(), wavefront.EventSearch{
+		Query: []wavefront.SearchCondition{{Key: "tags", Value: "deploy"}},
+	})
+
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.Event().ID)
+	}
+
+	if it.Err() != nil {
+		t.Fatalf("unexpected error occured: %v", it.Err())
+	}
+
+	if strings.Join(ids, ",") != "a,b,c" {
+		t.Fatalf("expected events a,b,c, but got %v", ids)
+	}
+}
+
+func TestIteratorStopsOnRepeatedCursor(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, `{"status": {}, "response": {"moreItems": true, "cursor": "a", "items": [{"id": "a"}]}}`)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "list"}, &http.Client{})
+
+	events, err := client.ListEvents(context.Background(), time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(events) != 2 || requests != 2 {
+		t.Fatalf("expected listing to stop once the cursor stopped moving, but made %d requests", requests)
+	}
+}
+
+func TestAlertFirings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/event/12345/events" || r.URL.Query().Get("isOverlapped") != "true" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		io.WriteString(w, `{"status": {}, "response": {"moreItems": false, "items": [
+			{"id": "alert-1", "creatorType": ["ALERT"]},
+			{"id": "deploy", "creatorType": ["USER"]},
+			{"id": "alert-2", "creatorType": ["ALERT", "SYSTEM"]}
+		]}}`)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "alerts"}, &http.Client{})
+
+	firings, err := client.AlertFirings(context.Background(), "12345")
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(firings) != 2 || firings[0].ID != "alert-1" || firings[1].ID != "alert-2" {
+		t.Fatalf("expected only the alert events, but got %+v", firings)
+	}
+}
+
+func TestTagsAndDelete(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Method, r.URL.EscapedPath(), body)))
+		io.WriteString(w, `{"status": {"result": "OK", "code": 200}}`)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "tags"}, &http.Client{})
+	ctx := context.Background()
+
+	if err := client.AddTag(ctx, "12345", "deploy/prod"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if err := client.RemoveTag(ctx, "12345", "canary"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if err := client.SetTags(ctx, "12345", []string{"a", "b"}); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if err := client.DeleteEvent(ctx, "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if err := client.AddTag(ctx, "12345", ""); !errors.Is(err, wavefront.ErrEmptyTag) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrEmptyTag, err)
+	}
+
+	expected := []string{
+		"PUT /api/v2/event/12345/tag/deploy%2Fprod",
+		"DELETE /api/v2/event/12345/tag/canary",
+		`POST /api/v2/event/12345/tag ["a","b"]`,
+		"DELETE /api/v2/event/12345",
+	}
+
+	if !reflect.DeepEqual(requests, expected) {
+		t.Fatalf("expected requests %v, but got %v", expected, requests)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"status": {"result": "ERROR", "message": "name must not be empty", "code": 400}}`)
+	}))
+	defer server.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: server.URL, WavefrontToken: "error"}, &http.Client{})
+
+	_, err := client.GetEvent(context.Background(), "12345")
+
+	var apiErr *wavefront.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, wavefront.ErrBadResponseStatus) {
+		t.Fatalf("expected an API error, but got %v", err)
+	}
+
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "name must not be empty" {
+		t.Fatalf("unexpected API error %+v", apiErr)
+	}
 }
 
 type testServerHandler struct {
-	retryCount int
+	retryCount  int
+	retryStatus int
+	retryAfter  string
+	bodies      []string
 }
 
 func (s *testServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -59,9 +412,20 @@ func (s *testServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	body, _ := ioutil.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+
 	if s.retryCount > 0 {
 		s.retryCount--
-		w.WriteHeader(http.StatusNotAcceptable)
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+
+		if s.retryStatus == 0 {
+			s.retryStatus = http.StatusNotAcceptable
+		}
+
+		w.WriteHeader(s.retryStatus)
 		return
 	}
 
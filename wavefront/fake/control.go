@@ -0,0 +1,97 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// controlPrefix is the path the server's control endpoints are served under. They let
+// processes other than the one running the server, such as a pipeline's tasks, inspect
+// the stored events and inject failures:
+//
+//		GET    /_fake/events    every stored event
+//		GET    /_fake/requests  every request served
+//		POST   /_fake/failures  add a failure, given as JSON, such as
+//		                        {"method": "POST", "path": "/api/v2/event", "status": 429, "count": 2}
+//		DELETE /_fake/failures  remove every failure
+//		POST   /_fake/reset     remove every event, failure and recorded request
+const controlPrefix = "/_fake/"
+
+// failureRequest is the JSON form of a Failure, with the latency as a duration string
+type failureRequest struct {
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Status     int    `json:"status,omitempty"`
+	RetryAfter string `json:"retry_after,omitempty"`
+	Latency    string `json:"latency,omitempty"`
+	Count      int    `json:"count,omitempty"`
+}
+
+// ParseFailure parses a failure from its JSON form, as accepted by the control endpoint
+func ParseFailure(data []byte) (Failure, error) {
+	var req failureRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return Failure{}, fmt.Errorf("could not parse failure: %w", err)
+	}
+
+	f := Failure{
+		Method:     req.Method,
+		Path:       req.Path,
+		Status:     req.Status,
+		RetryAfter: req.RetryAfter,
+		Count:      req.Count,
+	}
+
+	if req.Latency != "" {
+		latency, err := time.ParseDuration(req.Latency)
+		if err != nil {
+			return Failure{}, fmt.Errorf("could not parse latency: %w", err)
+		}
+
+		f.Latency = latency
+	}
+
+	if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+		return Failure{}, fmt.Errorf("status must be between 400 and 599, but was %d", f.Status)
+	}
+
+	return f, nil
+}
+
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, controlPrefix) + " " + r.Method {
+	case "events " + http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Events())
+	case "requests " + http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Requests())
+	case "failures " + http.MethodPost:
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		f, err := ParseFailure(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.InjectFailure(f)
+		writeStatus(w)
+	case "failures " + http.MethodDelete:
+		s.ClearFailures()
+		writeStatus(w)
+	case "reset " + http.MethodPost:
+		s.Reset()
+		writeStatus(w)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
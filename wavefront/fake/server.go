@@ -0,0 +1,710 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fake implements an in-memory Wavefront events API, for tests and for running
+// pipelines locally without a Wavefront tenant. Events move through the same running
+// states as they do in Wavefront, and requests can be made to fail or be delayed
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+// defaultPageSize is the page size used when a list request has no limit
+const defaultPageSize = 100
+
+// Server is an in-memory Wavefront events API. It is an http.Handler, so it can be
+// served with httptest.NewServer or http.ListenAndServe
+type Server struct {
+	token   string
+	now     func() time.Time
+	latency time.Duration
+
+	mu       sync.Mutex
+	events   map[string]*wavefront.Event
+	nextID   int
+	failures []*Failure
+	requests []string
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithToken makes the server reject requests that do not carry the token as a bearer token.
+// Without it, any token is accepted
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithClock sets the func the server gets the current time from
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithLatency delays every response by the given duration
+func WithLatency(latency time.Duration) Option {
+	return func(s *Server) {
+		s.latency = latency
+	}
+}
+
+// New returns an empty server
+func New(opts ...Option) *Server {
+	s := &Server{
+		now:    time.Now,
+		events: map[string]*wavefront.Event{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Failure makes the server delay matching requests, respond to them with an error status,
+// or both
+type Failure struct {
+	// Method matches requests with this method. Empty matches any method
+	Method string
+	// Path matches requests whose path starts with this. Empty matches any path
+	Path string
+	// Status is the status to respond with. 0 serves the request as normal after Latency
+	Status int
+	// RetryAfter is sent as the Retry-After header with the status, if set
+	RetryAfter string
+	// Latency delays the response, in addition to any latency the server was created with
+	Latency time.Duration
+	// Count is the number of requests the failure applies to. 0 applies it to every request
+	Count int
+}
+
+func (f *Failure) matches(r *http.Request) bool {
+	return (f.Method == "" || strings.EqualFold(f.Method, r.Method)) && strings.HasPrefix(r.URL.Path, f.Path)
+}
+
+// InjectFailure adds a failure. When several failures match a request, the first one
+// added is used
+func (s *Server) InjectFailure(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &f)
+}
+
+// ClearFailures removes every failure
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = nil
+}
+
+// AddEvent stores an event as is, giving it an ID if it has none, and returns the stored copy
+func (s *Server) AddEvent(event wavefront.Event) wavefront.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.ID == "" {
+		event.ID = s.newID(event.StartTime)
+	}
+
+	s.events[event.ID] = copyEvent(&event)
+	return *copyEvent(&event)
+}
+
+// Event returns the stored event with the given ID
+func (s *Server) Event(id string) (wavefront.Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event, ok := s.events[id]
+	if !ok {
+		return wavefront.Event{}, false
+	}
+
+	return *copyEvent(event), true
+}
+
+// Events returns every stored event, ordered by start time
+func (s *Server) Events() []wavefront.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]wavefront.Event, 0, len(s.events))
+	for _, event := range s.sortedEvents() {
+		events = append(events, *copyEvent(event))
+	}
+
+	return events
+}
+
+// Requests returns the method and path of every request served, in order, such as
+// "POST /api/v2/event"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// Reset removes every event, failure and recorded request
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = map[string]*wavefront.Event{}
+	s.failures = nil
+	s.requests = nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, controlPrefix) {
+		s.serveControl(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	failure := s.takeFailure(r)
+	s.mu.Unlock()
+
+	latency := s.latency
+	if failure != nil {
+		latency += failure.Latency
+	}
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if failure != nil && failure.Status != 0 {
+		if failure.RetryAfter != "" {
+			w.Header().Set("Retry-After", failure.RetryAfter)
+		}
+
+		writeError(w, failure.Status, "injected failure")
+		return
+	}
+
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	segments, err := pathSegments(r.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.route(w, r, segments)
+}
+
+// takeFailure returns the first failure matching the request, using up one of its count
+func (s *Server) takeFailure(r *http.Request) *Failure {
+	for i, f := range s.failures {
+		if !f.matches(r) {
+			continue
+		}
+
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				s.failures = append(s.failures[:i:i], s.failures[i+1:]...)
+			}
+		}
+
+		return f
+	}
+
+	return nil
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) < 3 || segments[0] != "api" || segments[1] != "v2" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(segments) == 4 && segments[2] == "search" && segments[3] == "event" && r.Method == http.MethodPost:
+		s.searchEvents(w, r)
+	case segments[2] != "event":
+		writeError(w, http.StatusNotFound, "not found")
+	case len(segments) == 3 && r.Method == http.MethodGet:
+		s.listEvents(w, r)
+	case len(segments) == 3 && r.Method == http.MethodPost:
+		s.createEvent(w, r)
+	case len(segments) == 4 && r.Method == http.MethodGet:
+		s.withEvent(w, segments[3], func(event *wavefront.Event) { writeEvent(w, event) })
+	case len(segments) == 4 && r.Method == http.MethodPut:
+		s.withEvent(w, segments[3], func(event *wavefront.Event) { s.updateEvent(w, r, event) })
+	case len(segments) == 4 && r.Method == http.MethodDelete:
+		s.withEvent(w, segments[3], func(event *wavefront.Event) {
+			delete(s.events, event.ID)
+			writeEvent(w, event)
+		})
+	case len(segments) == 5 && segments[4] == "close" && r.Method == http.MethodPost:
+		s.withEvent(w, segments[3], func(event *wavefront.Event) { s.closeEvent(w, event) })
+	case len(segments) == 5 && segments[4] == "events" && r.Method == http.MethodGet:
+		s.withEvent(w, segments[3], func(event *wavefront.Event) { s.relatedEvents(w, r, event) })
+	case len(segments) == 5 && segments[4] == "tag" && r.Method == http.MethodPost:
+		s.withEvent(w, segments[3], func(event *wavefront.Event) { s.setTags(w, r, event) })
+	case len(segments) == 6 && segments[4] == "tag" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		s.withEvent(w, segments[3], func(event *wavefront.Event) { s.changeTag(w, r.Method, event, segments[5]) })
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) withEvent(w http.ResponseWriter, id string, f func(*wavefront.Event)) {
+	event, ok := s.events[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("event %s not found", id))
+		return
+	}
+
+	f(event)
+}
+
+func (s *Server) createEvent(w http.ResponseWriter, r *http.Request) {
+	var event wavefront.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not parse event: %v", err))
+		return
+	}
+
+	if event.Name == "" {
+		writeError(w, http.StatusBadRequest, "name must not be empty")
+		return
+	}
+
+	if event.Annotations == nil {
+		writeError(w, http.StatusBadRequest, "annotations must not be null")
+		return
+	}
+
+	now := millis(s.now())
+	if event.StartTime == 0 {
+		event.StartTime = now
+	}
+
+	event.ID = s.newID(event.StartTime)
+	event.CreatorID = "fake"
+	event.CreatorType = []string{wavefront.CreatorUser}
+	event.UpdaterID = "fake"
+	event.CreatedEpochMillis = now
+	event.UpdatedEpochMillis = now
+	event.RunningState = runningState(&event, now)
+
+	s.events[event.ID] = copyEvent(&event)
+	writeEvent(w, &event)
+}
+
+func (s *Server) updateEvent(w http.ResponseWriter, r *http.Request, event *wavefront.Event) {
+	var update wavefront.Event
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not parse event: %v", err))
+		return
+	}
+
+	if update.Name == "" {
+		writeError(w, http.StatusBadRequest, "name must not be empty")
+		return
+	}
+
+	now := millis(s.now())
+	event.Name = update.Name
+	event.Annotations = update.Annotations
+	event.Tags = update.Tags
+	event.Hosts = update.Hosts
+	if update.StartTime != 0 {
+		event.StartTime = update.StartTime
+	}
+	event.EndTime = update.EndTime
+	event.UpdatedEpochMillis = now
+	event.RunningState = runningState(event, now)
+
+	writeEvent(w, event)
+}
+
+func (s *Server) closeEvent(w http.ResponseWriter, event *wavefront.Event) {
+	now := millis(s.now())
+	if event.RunningState != wavefront.StateEnded {
+		event.EndTime = now
+		if event.EndTime <= event.StartTime {
+			event.EndTime = event.StartTime + 1
+		}
+
+		event.UpdatedEpochMillis = now
+		event.RunningState = wavefront.StateEnded
+	}
+
+	writeEvent(w, event)
+}
+
+func (s *Server) setTags(w http.ResponseWriter, r *http.Request, event *wavefront.Event) {
+	var tags []string
+	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not parse tags: %v", err))
+		return
+	}
+
+	event.Tags = tags
+	writeStatus(w)
+}
+
+func (s *Server) changeTag(w http.ResponseWriter, method string, event *wavefront.Event, tag string) {
+	tags := event.Tags[:0:0]
+	for _, t := range event.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+
+	if method == http.MethodPut {
+		tags = append(tags, tag)
+	}
+
+	event.Tags = tags
+	writeStatus(w)
+}
+
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	earliest, err := int64Param(query, "earliestStartTimeEpochMillis", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	latest, err := int64Param(query, "latestStartTimeEpochMillis", millis(s.now()))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var events []*wavefront.Event
+	for _, event := range s.sortedEvents() {
+		if event.StartTime >= earliest && event.StartTime <= latest {
+			events = append(events, event)
+		}
+	}
+
+	s.writeCursorPage(w, query, events)
+}
+
+func (s *Server) relatedEvents(w http.ResponseWriter, r *http.Request, related *wavefront.Event) {
+	end := related.EndTime
+	if end == 0 {
+		end = millis(s.now())
+	}
+
+	var events []*wavefront.Event
+	for _, event := range s.sortedEvents() {
+		if event.ID == related.ID {
+			continue
+		}
+
+		eventEnd := event.EndTime
+		if eventEnd == 0 {
+			eventEnd = millis(s.now())
+		}
+
+		if event.StartTime <= end && eventEnd >= related.StartTime {
+			events = append(events, event)
+		}
+	}
+
+	s.writeCursorPage(w, r.URL.Query(), events)
+}
+
+// writeCursorPage writes the page of events following the one whose ID is the cursor
+func (s *Server) writeCursorPage(w http.ResponseWriter, query url.Values, events []*wavefront.Event) {
+	limit, err := int64Param(query, "limit", defaultPageSize)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "limit must be a positive number")
+		return
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		for i, event := range events {
+			if event.ID == cursor {
+				events = events[i+1:]
+				break
+			}
+		}
+	}
+
+	writePage(w, events, int(limit), 0, func(last *wavefront.Event) string { return last.ID })
+}
+
+func (s *Server) searchEvents(w http.ResponseWriter, r *http.Request) {
+	var search wavefront.SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not parse search: %v", err))
+		return
+	}
+
+	for _, condition := range search.Query {
+		if _, ok := searchValues(nil, condition.Key); !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported search key %q", condition.Key))
+			return
+		}
+	}
+
+	var events []*wavefront.Event
+	for _, event := range s.sortedEvents() {
+		if search.TimeRange != nil && (event.StartTime < search.TimeRange.EarliestStartTimeEpochMillis ||
+			event.StartTime > search.TimeRange.LatestStartTimeEpochMillis) {
+			continue
+		}
+
+		if matchesSearch(event, search.Query) {
+			events = append(events, event)
+		}
+	}
+
+	if search.Offset < len(events) {
+		events = events[search.Offset:]
+	} else {
+		events = nil
+	}
+
+	limit := search.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	writePage(w, events, limit, search.Offset, nil)
+}
+
+// searchFields are the keys that can be searched on, besides annotations, and the values
+// they are matched against
+var searchFields = map[string]func(*wavefront.Event) []string{
+	"id":           func(e *wavefront.Event) []string { return []string{e.ID} },
+	"name":         func(e *wavefront.Event) []string { return []string{e.Name} },
+	"runningState": func(e *wavefront.Event) []string { return []string{e.RunningState} },
+	"tags":         func(e *wavefront.Event) []string { return e.Tags },
+	"creatorType":  func(e *wavefront.Event) []string { return e.CreatorType },
+}
+
+// searchValues returns the values of the event that the search key is matched against, and
+// whether the key can be searched on. An annotation's key is "annotations.<name>"
+func searchValues(event *wavefront.Event, key string) ([]string, bool) {
+	if name := strings.TrimPrefix(key, "annotations."); name != key && name != "" {
+		if event == nil {
+			return nil, true
+		}
+
+		if value, ok := event.Annotations[name]; ok {
+			return []string{value}, true
+		}
+
+		return nil, true
+	}
+
+	field, ok := searchFields[key]
+	if !ok || event == nil {
+		return nil, ok
+	}
+
+	return field(event), true
+}
+
+func matchesSearch(event *wavefront.Event, conditions []wavefront.SearchCondition) bool {
+	for _, condition := range conditions {
+		matched := false
+		values, _ := searchValues(event, condition.Key)
+		for _, value := range values {
+			if matchValue(value, condition.Value, condition.MatchingMethod) {
+				matched = true
+				break
+			}
+		}
+
+		if matched == condition.Negated {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchValue(value string, term string, method string) bool {
+	value, term = strings.ToLower(value), strings.ToLower(term)
+
+	switch strings.ToUpper(method) {
+	case "EXACT":
+		return value == term
+	case "STARTSWITH":
+		return strings.HasPrefix(value, term)
+	default:
+		return strings.Contains(value, term)
+	}
+}
+
+func (s *Server) sortedEvents() []*wavefront.Event {
+	events := make([]*wavefront.Event, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, event)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].StartTime != events[j].StartTime {
+			return events[i].StartTime < events[j].StartTime
+		}
+
+		return events[i].ID < events[j].ID
+	})
+
+	return events
+}
+
+func (s *Server) newID(startTime int64) string {
+	s.nextID++
+	if startTime == 0 {
+		startTime = millis(s.now())
+	}
+
+	return fmt.Sprintf("%d:fake:%d", startTime, s.nextID)
+}
+
+// runningState returns the state Wavefront gives an event at the given time
+func runningState(event *wavefront.Event, now int64) string {
+	switch {
+	case event.StartTime > now:
+		return wavefront.StatePending
+	case event.EndTime != 0 && event.EndTime <= now:
+		return wavefront.StateEnded
+	default:
+		return wavefront.StateOngoing
+	}
+}
+
+func writePage(w http.ResponseWriter, events []*wavefront.Event, limit int, offset int, cursor func(*wavefront.Event) string) {
+	page := wavefront.EventPage{
+		Items:  []wavefront.Event{},
+		Offset: offset,
+		Limit:  limit,
+	}
+
+	if len(events) > limit {
+		events = events[:limit]
+		page.MoreItems = true
+	}
+
+	for _, event := range events {
+		page.Items = append(page.Items, *event)
+	}
+
+	if page.MoreItems && cursor != nil {
+		page.Cursor = cursor(events[len(events)-1])
+	}
+
+	writeJSON(w, http.StatusOK, wavefront.EventListResponse{
+		Status:   okStatus(),
+		Response: page,
+	})
+}
+
+func writeEvent(w http.ResponseWriter, event *wavefront.Event) {
+	writeJSON(w, http.StatusOK, wavefront.EventResponse{
+		Status:   okStatus(),
+		Response: copyEvent(event),
+	})
+}
+
+func writeStatus(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": okStatus()})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"status": wavefront.Status{Result: "ERROR", Message: message, Code: status},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func okStatus() wavefront.Status {
+	return wavefront.Status{Result: "OK", Code: http.StatusOK}
+}
+
+// pathSegments splits the path into unescaped segments, so that IDs and tags may contain
+// an escaped /
+func pathSegments(u *url.URL) ([]string, error) {
+	segments := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+
+		segments[i] = unescaped
+	}
+
+	return segments, nil
+}
+
+func int64Param(query url.Values, name string, defaultValue int64) (int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+
+	return i, nil
+}
+
+func copyEvent(event *wavefront.Event) *wavefront.Event {
+	c := *event
+
+	if event.Annotations != nil {
+		c.Annotations = make(map[string]string, len(event.Annotations))
+		for k, v := range event.Annotations {
+			c.Annotations[k] = v
+		}
+	}
+
+	c.Tags = append([]string(nil), event.Tags...)
+	c.Hosts = append([]string(nil), event.Hosts...)
+	c.CreatorType = append([]string(nil), event.CreatorType...)
+
+	return &c
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
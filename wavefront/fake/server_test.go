@@ -0,0 +1,197 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
+)
+
+func newClient(t *testing.T, server *fake.Server) *wavefront.APIClient {
+	t.Helper()
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	return wavefront.NewAPIClient(resource.Source{
+		WavefrontURL:   ts.URL,
+		WavefrontToken: "fake",
+		Retry:          resource.RetryConfig{InitialInterval: "1ms"},
+	}, &http.Client{})
+}
+
+func TestEventLifecycle(t *testing.T) {
+	server := fake.New(fake.WithToken("fake"))
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	event, err := client.StartOngoingEvent(ctx, "Deploy", map[string]string{"severity": "info"}, []string{"deploy"})
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if event.ID == "" || event.RunningState != wavefront.StateOngoing || event.StartTime == 0 {
+		t.Fatalf("expected a new ongoing event, but got %+v", event)
+	}
+
+	if _, err = client.AddCheckpoint(ctx, event.ID, "migrations done", time.Now()); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if err = client.AddTag(ctx, event.ID, "prod"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	ended, err := client.EndOngoingEvent(ctx, event.ID, nil)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if ended.RunningState != wavefront.StateEnded || ended.EndTime < ended.StartTime {
+		t.Fatalf("expected the event to have ended, but got %+v", ended)
+	}
+
+	stored, ok := server.Event(event.ID)
+	if !ok {
+		t.Fatal("expected the event to be stored, but it was not")
+	}
+
+	if len(stored.Timeline()) != 1 || !reflect.DeepEqual(stored.Tags, []string{"deploy", "prod"}) {
+		t.Fatalf("expected the checkpoint and tag to be stored, but got %+v", stored)
+	}
+
+	if err = client.DeleteEvent(ctx, event.ID); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if _, err = client.GetEvent(ctx, event.ID); !errors.Is(err, wavefront.ErrEventNotFound) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrEventNotFound, err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(fake.New(fake.WithToken("secret")))
+	defer ts.Close()
+
+	client := wavefront.NewAPIClient(resource.Source{WavefrontURL: ts.URL, WavefrontToken: "wrong"}, &http.Client{})
+
+	if _, err := client.StartOngoingEvent(context.Background(), "Deploy", nil, nil); !errors.Is(err, wavefront.ErrUnauthorized) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrUnauthorized, err)
+	}
+}
+
+func TestListAndSearch(t *testing.T) {
+	server := fake.New()
+	start := time.Date(2020, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, name := range []string{"Deploy api", "Deploy web", "Incident", "Deploy worker"} {
+		server.AddEvent(wavefront.Event{
+			Name:         name,
+			StartTime:    start.Add(time.Duration(i)*time.Minute).UnixNano() / int64(time.Millisecond),
+			RunningState: wavefront.StateEnded,
+			Tags:         []string{strings.Fields(name)[0]},
+		})
+	}
+
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	events, err := client.ListEvents(ctx, start.Add(time.Minute), start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(events) != 3 || events[0].Name != "Deploy web" {
+		t.Fatalf("expected the 3 events in the time range, but got %+v", events)
+	}
+
+	events, err = client.SearchEvents(ctx, wavefront.EventSearch{
+		Query: []wavefront.SearchCondition{
+			{Key: "name", Value: "deploy", MatchingMethod: "STARTSWITH"},
+			{Key: "name", Value: "web", Negated: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(events) != 2 || events[0].Name != "Deploy api" || events[1].Name != "Deploy worker" {
+		t.Fatalf("unexpected search results %+v", events)
+	}
+}
+
+func TestFindChildEvents(t *testing.T) {
+	server := fake.New()
+	start := time.Date(2020, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, parent := range []string{"1", "", "1", "2"} {
+		server.AddEvent(wavefront.Event{
+			Name:         fmt.Sprintf("Deploy %d", i),
+			StartTime:    start.Add(time.Duration(i)*time.Minute).UnixNano() / int64(time.Millisecond),
+			RunningState: wavefront.StateOngoing,
+			Annotations:  map[string]string{wavefront.ParentEventAnnotation: parent},
+		})
+	}
+
+	children, err := newClient(t, server).FindChildEvents(context.Background(), "1", start)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(children) != 2 || children[0].Name != "Deploy 0" || children[1].Name != "Deploy 2" {
+		t.Fatalf("expected the children of event 1, but got %+v", children)
+	}
+
+	if requests := server.Requests(); len(requests) != 1 || requests[0] != "POST /api/v2/search/event" {
+		t.Fatalf("expected the children to be found with one search, but the requests were %v", requests)
+	}
+}
+
+func TestInjectedFailures(t *testing.T) {
+	server := fake.New()
+	server.InjectFailure(fake.Failure{Method: http.MethodPost, Path: "/api/v2/event", Status: http.StatusTooManyRequests, Count: 2})
+
+	client := newClient(t, server)
+
+	if _, err := client.StartOngoingEvent(context.Background(), "Deploy", nil, nil); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if requests := server.Requests(); len(requests) != 3 {
+		t.Fatalf("expected the client to retry twice, but the server saw %v", requests)
+	}
+
+	server.InjectFailure(fake.Failure{Status: http.StatusBadRequest})
+
+	if _, err := client.StartOngoingEvent(context.Background(), "Deploy", nil, nil); !errors.Is(err, wavefront.ErrValidation) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrValidation, err)
+	}
+}
+
+func TestParseFailure(t *testing.T) {
+	f, err := fake.ParseFailure([]byte(`{"path": "/api/v2/event", "status": 503, "latency": "2s", "count": 1}`))
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	expected := fake.Failure{Path: "/api/v2/event", Status: 503, Latency: 2 * time.Second, Count: 1}
+	if f != expected {
+		t.Fatalf("expected %+v, but got %+v", expected, f)
+	}
+
+	if _, err = fake.ParseFailure([]byte(`{"status": 200}`)); err == nil {
+		t.Fatal("an expected error did not occur")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// EventFilter selects events by name, tags, and annotations. Fields that are not set
+// match every event
+type EventFilter struct {
+	// Name is matched against the event name, and may contain shell glob patterns
+	Name string `json:"name,omitempty"`
+
+	// Tags must all be present on the event
+	Tags []string `json:"tags,omitempty"`
+
+	// Annotations must all be present on the event with the same values
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Validate ensures that the filter's name pattern is well formed
+func (f EventFilter) Validate() error {
+	if _, err := path.Match(f.Name, ""); err != nil {
+		return fmt.Errorf("invalid name pattern %q: %w", f.Name, err)
+	}
+
+	return nil
+}
+
+// IsEmpty reports whether the filter has no conditions, and so matches every event
+func (f EventFilter) IsEmpty() bool {
+	return f.Name == "" && len(f.Tags) == 0 && len(f.Annotations) == 0
+}
+
+// Matches reports whether the event satisfies every condition in the filter
+func (f EventFilter) Matches(event Event) bool {
+	if f.Name != "" {
+		if ok, err := path.Match(f.Name, event.Name); err != nil || !ok {
+			return false
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		present := make(map[string]bool, len(event.Tags))
+		for _, t := range event.Tags {
+			present[t] = true
+		}
+
+		for _, t := range f.Tags {
+			if !present[t] {
+				return false
+			}
+		}
+	}
+
+	for k, v := range f.Annotations {
+		if actual, ok := event.Annotations[k]; !ok || actual != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterEvents returns the events that match the filter, preserving their order
+func FilterEvents(events []Event, filter EventFilter) []Event {
+	matched := []Event{}
+	for _, event := range events {
+		if filter.Matches(event) {
+			matched = append(matched, event)
+		}
+	}
+
+	return matched
+}
+
+// DefaultFailureSeverities are the values of the severity annotation that mark an event as a failure
+var DefaultFailureSeverities = []string{"FAILED", "FAILURE", "ERROR", "SEVERE"}
+
+// IsFailureSeverity reports whether severity matches one of failures, ignoring case. If
+// failures is empty, DefaultFailureSeverities is used
+func IsFailureSeverity(severity string, failures []string) bool {
+	if len(failures) == 0 {
+		failures = DefaultFailureSeverities
+	}
+
+	for _, f := range failures {
+		if strings.EqualFold(severity, f) {
+			return true
+		}
+	}
+
+	return false
+}
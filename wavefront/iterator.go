@@ -0,0 +1,109 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+// EventIterator reads a list of events from the API one page at a time. Call Next until
+// it returns false, then check Err:
+//
+//		it := client.IterateEvents(ctx, earliest, latest)
+//		for it.Next() {
+//			event := it.Event()
+//			...
+//		}
+//		if err := it.Err(); err != nil {
+//			...
+//		}
+type EventIterator struct {
+	fetch func() (EventPage, error)
+	keep  func(Event) bool
+
+	page  []Event
+	event Event
+	done  bool
+	err   error
+}
+
+func newEventIterator(fetch func() (EventPage, error)) *EventIterator {
+	return &EventIterator{fetch: fetch}
+}
+
+// Next advances to the next event, requesting another page when the current one is used
+// up. It returns false when there are no more events or a request failed
+func (it *EventIterator) Next() bool {
+	for {
+		for len(it.page) > 0 {
+			event := it.page[0]
+			it.page = it.page[1:]
+
+			if it.keep == nil || it.keep(event) {
+				it.event = event
+				return true
+			}
+		}
+
+		if it.done || it.err != nil {
+			return false
+		}
+
+		page, err := it.fetch()
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page = page.Items
+		it.done = !page.MoreItems || len(page.Items) == 0
+	}
+}
+
+// Event returns the event Next advanced to
+func (it *EventIterator) Event() Event {
+	return it.event
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *EventIterator) Err() error {
+	return it.err
+}
+
+// All reads every remaining event
+func (it *EventIterator) All() ([]Event, error) {
+	var events []Event
+	for it.Next() {
+		events = append(events, it.Event())
+	}
+
+	if it.err != nil {
+		return nil, it.err
+	}
+
+	return events, nil
+}
+
+// cursorFetcher returns a fetch func for an API that pages with a cursor. Each call builds a
+// request for the page after the given cursor, which is empty for the first page. If a
+// page has no cursor, the ID of its last event is used, and if the cursor does not move
+// on, the page is treated as the last one
+func cursorFetcher(next func(cursor string) (EventPage, error)) func() (EventPage, error) {
+	var cursor string
+
+	return func() (EventPage, error) {
+		page, err := next(cursor)
+		if err != nil || len(page.Items) == 0 {
+			return page, err
+		}
+
+		previous := cursor
+		cursor = page.Cursor
+		if cursor == "" {
+			cursor = page.Items[len(page.Items)-1].ID
+		}
+
+		if cursor == previous {
+			page.MoreItems = false
+		}
+
+		return page, nil
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+)
+
+// dashboardPadding is added before and after an event when linking to a dashboard, so
+// that the event is visible in context
+const dashboardPadding = 5 * time.Minute
+
+// GetConcourseMetadata will return the following key-value pairs, limited to those
+// listed in source.MetadataFields if it is set. Fields that are not set on the
+// event are omitted.
+//
+//		key: name, value: <event name>
+//		key: state, value: <event state>
+//		key: severity, value: <severity annotation>
+//		key: start_time, value: <event start time>
+//		key: end_time, value: <event end time>
+//		key: duration, value: <event duration>
+//		key: tags, value: <comma separated tags>
+//		key: url, value: <link to the event, or to source.Dashboard during the event>
+func GetConcourseMetadata(event *Event, source resource.Source) (resource.Metadata, error) {
+	fields := source.MetadataFields
+	if len(fields) == 0 {
+		fields = resource.AllMetadataFields
+	}
+
+	metadata := resource.Metadata{}
+	for _, field := range fields {
+		value, err := getMetadataValue(event, source, field)
+		if err != nil {
+			return nil, err
+		}
+
+		if value == "" && field != "name" && field != "state" {
+			continue
+		}
+
+		metadata = append(metadata, resource.Metadatum{
+			Name:  field,
+			Value: value,
+		})
+	}
+
+	return metadata, nil
+}
+
+func getMetadataValue(event *Event, source resource.Source, field string) (string, error) {
+	switch field {
+	case "name":
+		return event.Name, nil
+	case "state":
+		return event.RunningState, nil
+	case "severity":
+		return event.Annotations["severity"], nil
+	case "start_time", "end_time", "duration":
+		start, end := event.Start(), event.End()
+
+		switch {
+		case field == "start_time" && !start.IsZero():
+			return start.UTC().Format(time.RFC3339), nil
+		case field == "end_time" && !end.IsZero():
+			return end.UTC().Format(time.RFC3339), nil
+		case field == "duration" && !start.IsZero() && !end.IsZero():
+			return end.Sub(start).String(), nil
+		}
+		return "", nil
+	case "tags":
+		return strings.Join(event.Tags, ","), nil
+	case "url":
+		return GetEventURL(event, source), nil
+	}
+
+	return "", fmt.Errorf("unknown metadata field %s", field)
+}
+
+// GetEventURL returns a link to the event in the tenant UI. If source.Dashboard is set,
+// the link will instead open that dashboard scoped to the event's time range. If no link
+// can be built, for example because the event was sent through a proxy, "" is returned
+func GetEventURL(event *Event, source resource.Source) string {
+	baseURL := strings.TrimSuffix(source.WavefrontURL, "/")
+	if baseURL == "" {
+		return ""
+	}
+
+	if source.Dashboard == "" {
+		// an event sent through a proxy has no ID that the tenant knows about
+		if IsProxyEventID(event.ID) {
+			return ""
+		}
+
+		return fmt.Sprintf("%s/events/%s", baseURL, url.PathEscape(event.ID))
+	}
+
+	start, end := event.Start(), event.End()
+	if start.IsZero() {
+		return fmt.Sprintf("%s/dashboards/%s", baseURL, url.PathEscape(source.Dashboard))
+	}
+
+	live := "!f"
+	if end.IsZero() {
+		live = "!t"
+		end = time.Now()
+	}
+
+	windowStart := start.Add(-dashboardPadding)
+	windowDuration := end.Add(dashboardPadding).Sub(windowStart)
+
+	return fmt.Sprintf("%s/dashboards/%s#_v01(g:(d:%d,ls:%s,s:%d))",
+		baseURL, url.PathEscape(source.Dashboard), int64(windowDuration/time.Second), live, windowStart.Unix())
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+func TestConcourseMetadata(t *testing.T) {
+	var event *wavefront.Event
+	if err := json.NewDecoder(strings.NewReader(endedEventJSON)).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+
+	source := resource.Source{WavefrontURL: "https://foo.wavefront.com/"}
+
+	metadata, err := wavefront.GetConcourseMetadata(event, source)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	expected := resource.Metadata{
+		{Name: "name", Value: "My event"},
+		{Name: "state", Value: "ENDED"},
+		{Name: "severity", Value: "info"},
+		{Name: "start_time", Value: "2020-11-01T12:00:00Z"},
+		{Name: "end_time", Value: "2020-11-01T12:01:30Z"},
+		{Name: "duration", Value: "1m30s"},
+		{Name: "tags", Value: "tag1,tag2"},
+		{Name: "url", Value: "https://foo.wavefront.com/events/1604232000000:My%20event"},
+	}
+
+	if !reflect.DeepEqual(expected, metadata) {
+		t.Fatalf("expected metadata to be %v, but it was %v", expected, metadata)
+	}
+
+	source.MetadataFields = []string{"state", "url"}
+	source.Dashboard = "deploys"
+
+	metadata, err = wavefront.GetConcourseMetadata(event, source)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	expected = resource.Metadata{
+		{Name: "state", Value: "ENDED"},
+		{Name: "url", Value: "https://foo.wavefront.com/dashboards/deploys#_v01(g:(d:690,ls:!f,s:1604231700))"},
+	}
+
+	if !reflect.DeepEqual(expected, metadata) {
+		t.Fatalf("expected metadata to be %v, but it was %v", expected, metadata)
+	}
+}
+
+const endedEventJSON = `
+{
+	"id": "1604232000000:My event",
+	"name": "My event",
+	"runningState": "ENDED",
+	"startTime": 1604232000000,
+	"endTime": 1604232090000,
+	"annotations": {
+		"severity": "info"
+	},
+	"tags": ["tag1", "tag2"]
+}
+`
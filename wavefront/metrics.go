@@ -0,0 +1,102 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMetricSource is the source reported with metric points that do not set one
+const DefaultMetricSource = "concourse"
+
+// Point is a single metric value, sent to Wavefront in its data format:
+//
+//		<metric> <value> [<timestamp>] source=<source> [<tagKey>="<tagValue>" ...]
+type Point struct {
+	Metric    string
+	Value     float64
+	Timestamp time.Time
+	Source    string
+	Tags      map[string]string
+}
+
+// String formats the point as a line in Wavefront data format
+func (p Point) String() string {
+	source := p.Source
+	if source == "" {
+		source = DefaultMetricSource
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%s %s", sanitizeMetricName(p.Metric), strconv.FormatFloat(p.Value, 'f', -1, 64))
+	if !p.Timestamp.IsZero() {
+		fmt.Fprintf(buf, " %d", p.Timestamp.Unix())
+	}
+	fmt.Fprintf(buf, " source=%s", quoteValue(source))
+
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(buf, " %s=%s", quoteValue(k), quoteValue(p.Tags[k]))
+	}
+
+	return buf.String()
+}
+
+// SendMetrics sends the given points to the tenant's direct ingestion endpoint
+func (a *APIClient) SendMetrics(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	body := &bytes.Buffer{}
+	for _, p := range points {
+		fmt.Fprintln(body, p.String())
+	}
+
+	req, err := a.newRequest(ctx, http.MethodPost, "/report?f=wavefront", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	response, err := a.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	return err
+}
+
+func sanitizeMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-', r == '_', r == '.', r == '/', r == ',':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+func quoteValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,32 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// placeholderEventIDPrefix marks a version ID that stands in for an event a put failed to
+// record, when the put was not to fail on errors
+const placeholderEventIDPrefix = "placeholder:"
+
+// NewPlaceholderEventID returns a unique ID for an event that could not be recorded at t
+func NewPlaceholderEventID(t time.Time) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		// the time alone is unique enough for the puts of a single pipeline
+		return fmt.Sprintf("%s%d", placeholderEventIDPrefix, toMillis(t))
+	}
+
+	return fmt.Sprintf("%s%d:%s", placeholderEventIDPrefix, toMillis(t), hex.EncodeToString(suffix))
+}
+
+// IsPlaceholderEventID reports whether the ID was produced by NewPlaceholderEventID
+func IsPlaceholderEventID(id string) bool {
+	return strings.HasPrefix(id, placeholderEventIDPrefix)
+}
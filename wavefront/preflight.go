@@ -0,0 +1,170 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// preflightDialTimeout limits how long the preflight waits to connect to the tenant
+const preflightDialTimeout = 10 * time.Second
+
+// preflightTimeout limits how long the whole preflight may take. Its requests are sent once,
+// without the client's retry policy, since the preflight is meant to fail fast
+const preflightTimeout = 30 * time.Second
+
+// preflightEventID is an ID no event has. The preflight tags it to check that the token
+// may modify events. This relies on the API checking the token's permissions before it
+// looks the event up: PUT /api/v2/event/{id}/tag/{tag} responds 403 to a token without
+// the Events permission, and 404 to one with it, since the event does not exist. Any
+// other response fails the check. Were the API to look the event up first, a token
+// without permission would pass, and the put itself would fail with 403
+const preflightEventID = "observability-event-resource-preflight"
+
+// Steps of the preflight, in the order they run
+const (
+	PreflightURL        = "url"
+	PreflightResolve    = "resolve"
+	PreflightConnect    = "connect"
+	PreflightToken      = "token"
+	PreflightPermission = "permission"
+)
+
+// PreflightError is returned when a step of the preflight fails. Remediation says how to fix it
+type PreflightError struct {
+	Step        string
+	Remediation string
+	Err         error
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("%v: %s check failed: %v", ErrPreflightFailed, e.Step, e.Err)
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// Is lets errors.Is match ErrPreflightFailed as well as the error the step failed with
+func (e *PreflightError) Is(target error) bool {
+	return target == ErrPreflightFailed
+}
+
+// Preflight checks, without modifying any event, that the client is able to manage events.
+// In turn, it checks that the tenant URL is an HTTPS URL, that its host, or the proxy used to
+// reach it, resolves and accepts connections, that the API accepts the token, and that the
+// token may modify events. The first failure is returned as a *PreflightError. The preflight
+// gives up after preflightTimeout, and does not retry requests that fail
+func (a *APIClient) Preflight(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	once := *a
+	once.retry = noRetryPolicy()
+	a = &once
+
+	u, err := url.Parse(a.baseURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		if err == nil {
+			err = fmt.Errorf("%q is not an absolute URL", a.baseURL)
+		}
+
+		return &PreflightError{
+			Step:        PreflightURL,
+			Remediation: "set tenant_url to your tenant's URL, such as https://longboard.wavefront.com",
+			Err:         err,
+		}
+	}
+
+	if u.Scheme != "https" && !isLoopback(u.Hostname()) {
+		return &PreflightError{
+			Step:        PreflightURL,
+			Remediation: "use an https:// tenant_url, so that the API token is not sent in the clear",
+			Err:         fmt.Errorf("%q does not use HTTPS", a.baseURL),
+		}
+	}
+
+	// behind a proxy, it is the proxy that has to be reachable
+	target, via := u, ""
+	if t, ok := a.transport.(*http.Transport); ok && t.Proxy != nil {
+		if proxyURL, err := t.Proxy(&http.Request{URL: u}); err == nil && proxyURL != nil {
+			target, via = proxyURL, "the proxy "
+		}
+	}
+
+	if _, err = net.DefaultResolver.LookupHost(ctx, target.Hostname()); err != nil {
+		return &PreflightError{
+			Step:        PreflightResolve,
+			Remediation: fmt.Sprintf("check that %s%s is spelled correctly, and that workers can resolve it", via, target.Hostname()),
+			Err:         err,
+		}
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = map[string]string{"https": "443", "http": "80"}[target.Scheme]
+	}
+
+	dialer := net.Dialer{Timeout: preflightDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.Hostname(), port))
+	if err != nil {
+		return &PreflightError{
+			Step:        PreflightConnect,
+			Remediation: fmt.Sprintf("check that workers are allowed to connect to %s%s on port %s, for example through a firewall", via, target.Hostname(), port),
+			Err:         err,
+		}
+	}
+	conn.Close()
+
+	// listing the last minute's events is about the cheapest request that needs the token
+	now := time.Now()
+	query := url.Values{}
+	query.Set("earliestStartTimeEpochMillis", strconv.FormatInt(toMillis(now.Add(-time.Minute)), 10))
+	query.Set("latestStartTimeEpochMillis", strconv.FormatInt(toMillis(now), 10))
+	query.Set("limit", "1")
+
+	req, err := a.newRequest(ctx, http.MethodGet, "/api/v2/event?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = a.doListRequest(req); err != nil {
+		return &PreflightError{Step: PreflightToken, Remediation: preflightRemediation(err), Err: err}
+	}
+
+	err = a.AddTag(ctx, preflightEventID, "preflight")
+	if err != nil && !errors.Is(err, ErrEventNotFound) {
+		return &PreflightError{Step: PreflightPermission, Remediation: preflightRemediation(err), Err: err}
+	}
+
+	return nil
+}
+
+// preflightRemediation returns the hint for an API failure, or general advice if there is none
+func preflightRemediation(err error) string {
+	if hint := Hint(err); hint != "" {
+		return hint
+	}
+
+	return "check that tenant_url is your tenant's URL, and not that of a proxy or dashboard"
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ErrPreflightFailed will be returned when the preflight finds that the client cannot manage events
+var ErrPreflightFailed = errors.New("preflight failed")
@@ -0,0 +1,107 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront/fake"
+)
+
+func TestPreflight(t *testing.T) {
+	server := fake.New(fake.WithToken("preflight"))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	testCases := []struct {
+		name     string
+		baseURL  string
+		token    string
+		failure  *fake.Failure
+		step     string
+		expected error
+	}{
+		{name: "ok", baseURL: ts.URL, token: "preflight"},
+		{name: "not a URL", baseURL: "longboard.wavefront.com", token: "preflight", step: wavefront.PreflightURL},
+		{name: "not HTTPS", baseURL: "http://longboard.wavefront.com", token: "preflight", step: wavefront.PreflightURL},
+		{name: "unresolvable", baseURL: "https://tenant.invalid", token: "preflight", step: wavefront.PreflightResolve},
+		{name: "bad token", baseURL: ts.URL, token: "wrong", step: wavefront.PreflightToken, expected: wavefront.ErrUnauthorized},
+		{
+			name:     "read only token",
+			baseURL:  ts.URL,
+			token:    "preflight",
+			failure:  &fake.Failure{Method: http.MethodPut, Status: http.StatusForbidden},
+			step:     wavefront.PreflightPermission,
+			expected: wavefront.ErrForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server.ClearFailures()
+			if tc.failure != nil {
+				server.InjectFailure(*tc.failure)
+			}
+
+			client := wavefront.New(wavefront.WithBaseURL(tc.baseURL), wavefront.WithToken(tc.token))
+
+			err := client.Preflight(context.Background())
+			if tc.step == "" {
+				if err != nil {
+					t.Fatalf("unexpected error occured: %v", err)
+				}
+
+				if len(server.Events()) != 0 {
+					t.Fatal("expected the preflight not to create any events, but it did")
+				}
+
+				return
+			}
+
+			var preflightErr *wavefront.PreflightError
+			if !errors.As(err, &preflightErr) || !errors.Is(err, wavefront.ErrPreflightFailed) {
+				t.Fatalf("expected a preflight error, but got %v", err)
+			}
+
+			if preflightErr.Step != tc.step || preflightErr.Remediation == "" || wavefront.Hint(err) != preflightErr.Remediation {
+				t.Fatalf("expected the %s step to fail with a remediation, but got %+v", tc.step, preflightErr)
+			}
+
+			if tc.expected != nil && !errors.Is(err, tc.expected) {
+				t.Fatalf("expected to get %v as an error but got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestPreflightDoesNotRetry(t *testing.T) {
+	server := fake.New(fake.WithToken("preflight"))
+	server.InjectFailure(fake.Failure{Method: http.MethodGet, Status: http.StatusServiceUnavailable})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// the default retry policy would keep trying for two minutes
+	client := wavefront.New(wavefront.WithBaseURL(ts.URL), wavefront.WithToken("preflight"))
+
+	start := time.Now()
+	err := client.Preflight(context.Background())
+	if !errors.Is(err, wavefront.ErrServerError) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrServerError, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the preflight to fail fast, but it took %v", elapsed)
+	}
+
+	if requests := server.Requests(); len(requests) != 1 {
+		t.Fatalf("expected the failing request to be sent once, but the requests were %v", requests)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// proxyEventIDPrefix marks a version ID that was produced in proxy mode. Because a proxy
+// does not return event IDs, the ID instead encodes the event itself
+const proxyEventIDPrefix = "proxy:"
+
+// proxyDialTimeout bounds how long connecting to a proxy over TCP may take
+const proxyDialTimeout = 30 * time.Second
+
+// ProxyClient sends events and metrics to a Wavefront proxy rather than to the REST API.
+// A proxy can only create events with a known start and end time; it cannot return,
+// look up, update, or close an event
+type ProxyClient struct {
+	client  *http.Client
+	address *url.URL
+}
+
+// NewProxyClient creates a client for the proxy at address, which is either host:port or
+// tcp://host:port to send over TCP, or an http:// or https:// URL to send over HTTP
+func NewProxyClient(address string, client *http.Client) (*ProxyClient, error) {
+	if !strings.Contains(address, "://") {
+		address = "tcp://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address %s: %w", address, err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid proxy address %s: scheme must be tcp, http, or https", address)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address %s: missing host", address)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &ProxyClient{
+		client:  client,
+		address: u,
+	}, nil
+}
+
+// SendEvent sends an event in the proxy's @Event format
+func (p *ProxyClient) SendEvent(ctx context.Context, name string, annotations map[string]string, tags []string, start time.Time, end time.Time) error {
+	return p.send(ctx, FormatProxyEvent(name, annotations, tags, start, end)+"\n")
+}
+
+// SendMetrics sends the given points in Wavefront data format
+func (p *ProxyClient) SendMetrics(ctx context.Context, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	buf := &strings.Builder{}
+	for _, point := range points {
+		fmt.Fprintln(buf, point.String())
+	}
+
+	return p.send(ctx, buf.String())
+}
+
+func (p *ProxyClient) send(ctx context.Context, lines string) error {
+	if p.address.Scheme == "tcp" {
+		dialer := net.Dialer{Timeout: proxyDialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", p.address.Host)
+		if err != nil {
+			return fmt.Errorf("could not connect to proxy: %w", err)
+		}
+		defer conn.Close()
+
+		if deadline, ok := ctx.Deadline(); ok {
+			if err = conn.SetWriteDeadline(deadline); err != nil {
+				return fmt.Errorf("could not connect to proxy: %w", err)
+			}
+		}
+
+		if _, err = io.WriteString(conn, lines); err != nil {
+			return fmt.Errorf("could not send to proxy: %w", err)
+		}
+
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.address.String(), strings.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	response, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send to proxy: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return badResponseError(response)
+	}
+
+	return nil
+}
+
+// FormatProxyEvent formats an event as a line in the proxy's @Event format:
+//
+//		@Event <startMillis> <endMillis> "<name>" [tag="<tag>" ...] ["<key>"="<value>" ...]
+func FormatProxyEvent(name string, annotations map[string]string, tags []string, start time.Time, end time.Time) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "@Event %d %d %s", toMillis(start), toMillis(end), quoteValue(name))
+
+	for _, t := range tags {
+		fmt.Fprintf(buf, " tag=%s", quoteValue(t))
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(buf, " %s=%s", quoteValue(k), quoteValue(annotations[k]))
+	}
+
+	return buf.String()
+}
+
+type proxyEvent struct {
+	Name        string            `json:"name"`
+	StartTime   int64             `json:"startTime"`
+	EndTime     int64             `json:"endTime,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+}
+
+// NewProxyEvent builds an event object, like those returned by the API, for an event sent
+// through a proxy. Its ID encodes the event so that it can be recovered with DecodeProxyEventID.
+// If end is the zero time, the event is ONGOING
+func NewProxyEvent(name string, annotations map[string]string, tags []string, start time.Time, end time.Time) (*Event, error) {
+	e := proxyEvent{
+		Name:        name,
+		StartTime:   toMillis(start),
+		Annotations: annotations,
+		Tags:        tags,
+	}
+
+	if !end.IsZero() {
+		e.EndTime = toMillis(end)
+	}
+
+	eventBytes, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.toEvent(proxyEventIDPrefix + base64.RawURLEncoding.EncodeToString(eventBytes)), nil
+}
+
+// IsProxyEventID reports whether the ID was produced by NewProxyEvent
+func IsProxyEventID(id string) bool {
+	return strings.HasPrefix(id, proxyEventIDPrefix)
+}
+
+// DecodeProxyEventID recovers the event object from an ID produced by NewProxyEvent
+func DecodeProxyEventID(id string) (*Event, error) {
+	if !IsProxyEventID(id) {
+		return nil, fmt.Errorf("%s is not a proxy event ID", id)
+	}
+
+	eventBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, proxyEventIDPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode proxy event ID: %w", err)
+	}
+
+	var e proxyEvent
+	if err = json.Unmarshal(eventBytes, &e); err != nil {
+		return nil, fmt.Errorf("could not decode proxy event ID: %w", err)
+	}
+
+	return e.toEvent(id), nil
+}
+
+func (e proxyEvent) toEvent(id string) *Event {
+	state := StateOngoing
+	if e.EndTime > 0 {
+		state = StateEnded
+	}
+
+	return &Event{
+		ID:           id,
+		Name:         e.Name,
+		StartTime:    e.StartTime,
+		EndTime:      e.EndTime,
+		RunningState: state,
+		Annotations:  e.Annotations,
+		Tags:         e.Tags,
+	}
+}
+
+// ErrUnsupportedByProxy will be returned when an operation needs the REST API but a proxy is configured
+var ErrUnsupportedByProxy = errors.New("not supported when sending through a proxy")
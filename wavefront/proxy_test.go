@@ -0,0 +1,29 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+func TestFormatProxyEvent(t *testing.T) {
+	start := time.Unix(1604232000, 0)
+	annotations := map[string]string{
+		"severity":      "info",
+		"build url":     "/builds/1",
+		"a=b":           "c",
+		`say "hello"`:   "world",
+		"concourse-job": "",
+	}
+
+	line := wavefront.FormatProxyEvent("My event", annotations, []string{"tag1"}, start, start.Add(time.Minute))
+
+	expected := `@Event 1604232000000 1604232060000 "My event" tag="tag1" "a=b"="c" "build url"="/builds/1" "concourse-job"="" "say \"hello\""="world" "severity"="info"`
+	if line != expected {
+		t.Fatalf("expected the event to be formatted as %s, but it was %s", expected, line)
+	}
+}
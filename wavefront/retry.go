@@ -0,0 +1,278 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	resource "github.com/vmware-tanzu/observability-event-resource"
+)
+
+// RetryPolicy decides which failed requests are sent again, and how long to keep trying
+type RetryPolicy struct {
+	MaxElapsedTime       time.Duration
+	InitialInterval      time.Duration
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy retries for up to two minutes when the API is busy, rate limiting,
+// or failing with a gateway or server error
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxElapsedTime:  2 * time.Minute,
+		InitialInterval: backoff.DefaultInitialInterval,
+		RetryableStatusCodes: []int{
+			http.StatusNotAcceptable,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// NewRetryPolicy overrides the defaults with whatever is set in the source's retry block.
+// Values rejected by resource.RetryConfig.Validate are ignored
+func NewRetryPolicy(config resource.RetryConfig) RetryPolicy {
+	policy := DefaultRetryPolicy()
+
+	if d, err := time.ParseDuration(config.MaxElapsedTime); err == nil && d >= 0 {
+		policy.MaxElapsedTime = d
+	}
+
+	if d, err := time.ParseDuration(config.InitialInterval); err == nil && d > 0 {
+		policy.InitialInterval = d
+	}
+
+	if len(config.RetryableStatusCodes) > 0 {
+		policy.RetryableStatusCodes = config.RetryableStatusCodes
+	}
+
+	return policy
+}
+
+// noRetryPolicy sends each request once: no status is retryable, and any wait before
+// retrying an error exceeds its elapsed time
+func noRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxElapsedTime: time.Nanosecond, InitialInterval: backoff.DefaultInitialInterval}
+}
+
+func (p RetryPolicy) newBackOff() *backoff.ExponentialBackOff {
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = p.InitialInterval
+	exp.MaxElapsedTime = p.MaxElapsedTime
+	exp.Reset()
+
+	return exp
+}
+
+func (p RetryPolicy) isRetryableStatus(code int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doRequest sends the request, retrying according to the client's retry policy, and returns
+// the response if its status was 2xx. Each attempt is sent with a fresh copy of the request
+// body and is limited to the client's request timeout, and the server's Retry-After header is
+// honored. Retrying stops as soon as the request's context is done. The caller must close the
+// response body
+func (a *APIClient) doRequest(req *http.Request) (*http.Response, error) {
+	if err := makeRewindable(req); err != nil {
+		return nil, err
+	}
+
+	exp := a.retry.newBackOff()
+	for {
+		response, cancel, err := a.doAttempt(req)
+		if err == nil && response.StatusCode >= 200 && response.StatusCode <= 299 {
+			response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+			return response, nil
+		}
+
+		var wait time.Duration
+		if err != nil {
+			cancel()
+			if req.Context().Err() != nil || !isRetryableError(err) {
+				return nil, err
+			}
+		} else {
+			if !a.retry.isRetryableStatus(response.StatusCode) {
+				err = badResponseError(response)
+				cancel()
+				return nil, err
+			}
+
+			wait = parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+		}
+
+		next := exp.NextBackOff()
+		if next != backoff.Stop && wait > next {
+			next = wait
+		}
+
+		if next == backoff.Stop || (exp.MaxElapsedTime != 0 && exp.GetElapsedTime()+next > exp.MaxElapsedTime) {
+			if err == nil {
+				err = badResponseError(response)
+				cancel()
+			}
+
+			return nil, err
+		}
+
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+			cancel()
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// doAttempt sends a copy of the request with an unread body, limited to the client's request
+// timeout. The returned cancel func must be called once the response has been read
+func (a *APIClient) doAttempt(req *http.Request) (*http.Response, context.CancelFunc, error) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	if a.requestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), a.requestTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+
+	attempt, err := rewind(ctx, req)
+	if err != nil {
+		return nil, cancel, err
+	}
+
+	response, err := a.client.Do(attempt)
+	return response, cancel, err
+}
+
+// cancelOnClose releases an attempt's context once its response body has been read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// makeRewindable buffers a request body that cannot be recreated, so that it can be
+// sent again on retry
+func makeRewindable(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+
+	return nil
+}
+
+// rewind returns a copy of the request with an unread body and the given context
+func rewind(ctx context.Context, req *http.Request) (*http.Request, error) {
+	attempt := req.Clone(ctx)
+	if req.GetBody == nil {
+		return attempt, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	attempt.Body = body
+
+	return attempt, nil
+}
+
+// isRetryableError reports whether a transport error is likely to be temporary, such as a
+// refused or reset connection or a timeout. Certificate problems and unknown hosts are not
+func isRetryableError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		dnsErr           *net.DNSError
+		urlErr           *url.Error
+		netErr           net.Error
+	)
+
+	switch {
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert), errors.As(err, &hostname):
+		return false
+	case errors.As(err, &dnsErr):
+		return !dnsErr.IsNotFound
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return true
+	}
+
+	// *url.Error is itself a net.Error, so look at what it wraps instead
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	return errors.As(err, &netErr)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(header); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+
+	return 0
+}
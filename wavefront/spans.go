@@ -0,0 +1,320 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+)
+
+// DefaultSpanApplication is the application tag reported with spans that do not set one
+const DefaultSpanApplication = "concourse"
+
+// DefaultSpanService is the service tag reported with spans that do not set one, since
+// Wavefront span format requires it
+const DefaultSpanService = "pipeline"
+
+// Span is a single unit of work in a distributed trace. IDs are lowercase hex strings,
+// 32 characters for a trace ID and 16 for a span ID, as used by OpenTelemetry
+type Span struct {
+	Name         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Start        time.Time
+	End          time.Time
+	Application  string
+	Service      string
+	Source       string
+	Tags         map[string]string
+	Error        bool
+}
+
+// NewTraceID generates a random trace ID
+func NewTraceID() (string, error) {
+	return randomHex(16)
+}
+
+// NewSpanID generates a random span ID
+func NewSpanID() (string, error) {
+	return randomHex(8)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate ID: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// String formats the span as a line in Wavefront span format:
+//
+//		<name> source=<source> traceId=<uuid> spanId=<uuid> [parent=<uuid>] application=<app> service=<service> [<tags>] <startMillis> <durationMillis>
+func (s Span) String() string {
+	source := s.Source
+	if source == "" {
+		source = DefaultMetricSource
+	}
+
+	application := s.Application
+	if application == "" {
+		application = DefaultSpanApplication
+	}
+
+	service := s.Service
+	if service == "" {
+		service = DefaultSpanService
+	}
+
+	tags := map[string]string{
+		"application": application,
+		"service":     service,
+	}
+
+	for k, v := range s.Tags {
+		tags[k] = v
+	}
+
+	if s.Error {
+		tags["error"] = "true"
+	}
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%s source=%s traceId=%s spanId=%s", quoteValue(s.Name), quoteValue(source), hexToUUID(s.TraceID), hexToUUID(s.SpanID))
+	if s.ParentSpanID != "" {
+		fmt.Fprintf(buf, " parent=%s", hexToUUID(s.ParentSpanID))
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(buf, " %s=%s", quoteValue(k), quoteValue(tags[k]))
+	}
+
+	fmt.Fprintf(buf, " %d %d", toMillis(s.Start), toMillis(s.End)-toMillis(s.Start))
+
+	return buf.String()
+}
+
+// hexToUUID left-pads a hex ID to 128 bits and formats it as a UUID, as Wavefront span
+// format requires
+func hexToUUID(id string) string {
+	id = strings.Repeat("0", 32-len(id)) + id
+	return fmt.Sprintf("%s-%s-%s-%s-%s", id[0:8], id[8:12], id[12:16], id[16:20], id[20:32])
+}
+
+// SendSpans sends the given spans to the tenant's direct ingestion endpoint in Wavefront span format
+func (a *APIClient) SendSpans(ctx context.Context, spans []Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body := &bytes.Buffer{}
+	for _, s := range spans {
+		fmt.Fprintln(body, s.String())
+	}
+
+	req, err := a.newRequest(ctx, http.MethodPost, "/report?f=trace", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	response, err := a.doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	return err
+}
+
+// NewOTLPClient returns a client for sending spans to the source's OTLP endpoint, with the
+// same TLS and proxy settings and request timeout as the API client. Requests are sent with
+// the transport of client, if it is set
+func NewOTLPClient(source resource.Source, client *http.Client) (*http.Client, error) {
+	var transport http.RoundTripper
+	if client != nil {
+		transport = client.Transport
+	}
+
+	transport, err := ConfigureTransport(source, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: transport, Timeout: requestTimeout(source.RequestTimeout)}, nil
+}
+
+// SendOTLPSpans sends the given spans to an OpenTelemetry collector's OTLP/HTTP traces
+// endpoint, such as http://collector:4318/v1/traces, encoded as JSON
+func SendOTLPSpans(ctx context.Context, client *http.Client, endpoint string, spans []Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	bodyBytes, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return fmt.Errorf("could not serialize spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return badResponseError(response)
+	}
+
+	return nil
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpRequest converts spans into an ExportTraceServiceRequest, with one resource per service
+func otlpRequest(spans []Span) map[string][]otlpResourceSpans {
+	const (
+		spanKindInternal = 1
+		statusCodeOK     = 1
+		statusCodeError  = 2
+	)
+
+	byService := map[string]*otlpResourceSpans{}
+	var services []string
+
+	for _, s := range spans {
+		service := s.Service
+		if service == "" {
+			service = DefaultSpanService
+		}
+
+		rs, ok := byService[service]
+		if !ok {
+			application := s.Application
+			if application == "" {
+				application = DefaultSpanApplication
+			}
+
+			attributes := map[string]string{"application": application, "service.name": service}
+
+			rs = &otlpResourceSpans{}
+			rs.Resource.Attributes = otlpAttributes(attributes)
+
+			scope := otlpScopeSpans{}
+			scope.Scope.Name = "observability-event-resource"
+			scope.Scope.Version = resource.AppVersion
+			rs.ScopeSpans = []otlpScopeSpans{scope}
+
+			byService[service] = rs
+			services = append(services, service)
+		}
+
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentSpanID,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Tags),
+		}
+
+		span.Status.Code = statusCodeOK
+		if s.Error {
+			span.Status.Code = statusCodeError
+		}
+
+		rs.ScopeSpans[0].Spans = append(rs.ScopeSpans[0].Spans, span)
+	}
+
+	resourceSpans := make([]otlpResourceSpans, len(services))
+	for i, service := range services {
+		resourceSpans[i] = *byService[service]
+	}
+
+	return map[string][]otlpResourceSpans{"resourceSpans": resourceSpans}
+}
+
+func otlpAttributes(m map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		attributes[i].Key = k
+		attributes[i].Value.StringValue = m[k]
+	}
+
+	return attributes
+}
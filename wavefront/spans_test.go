@@ -0,0 +1,67 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+func TestOTLPClient(t *testing.T) {
+	received := make(chan string, 1)
+	collector := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer collector.Close()
+
+	span := wavefront.Span{
+		Name:    "Deploy",
+		TraceID: "0af7651916cd43dd8448eb211c80319c",
+		SpanID:  "00f067aa0ba902b7",
+		Start:   time.Unix(1604232000, 0),
+		End:     time.Unix(1604232090, 0),
+	}
+
+	source := resource.Source{OTLPEndpoint: collector.URL}
+
+	client, err := wavefront.NewOTLPClient(source, &http.Client{})
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if err = wavefront.SendOTLPSpans(context.Background(), client, source.OTLPEndpoint, []wavefront.Span{span}); err == nil {
+		t.Fatal("expected the collector's certificate to be rejected without ca_cert, but it was not")
+	}
+
+	source.CACert = serverCertPEM(collector)
+	if client, err = wavefront.NewOTLPClient(source, &http.Client{}); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if client.Timeout != wavefront.DefaultRequestTimeout {
+		t.Fatalf("expected the client to time out after %v, but it was %v", wavefront.DefaultRequestTimeout, client.Timeout)
+	}
+
+	if err = wavefront.SendOTLPSpans(context.Background(), client, source.OTLPEndpoint, []wavefront.Span{span}); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	// a span with no service is reported with the default one
+	if body := <-received; !strings.Contains(body, `"key":"service.name","value":{"stringValue":"pipeline"}`) {
+		t.Fatalf("expected the default service.name attribute, but the request was %s", body)
+	}
+
+	if line := span.String(); !strings.Contains(line, `"service"="pipeline"`) {
+		t.Fatalf("expected the default service tag, but the span was %s", line)
+	}
+}
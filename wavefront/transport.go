@@ -0,0 +1,156 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+)
+
+// ConfigureTransport returns a transport that applies the source's TLS and proxy settings
+// to base, which defaults to http.DefaultTransport. If the source has none, base is returned
+// as it is. Otherwise base must be an *http.Transport, and is cloned rather than modified
+func ConfigureTransport(source resource.Source, base http.RoundTripper) (http.RoundTripper, error) {
+	if !source.TLSConfig.IsSet() && source.HTTPProxy == "" {
+		return base, nil
+	}
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot apply TLS and proxy settings to a %T", ErrUnsupportedTransport, base)
+	}
+	t = t.Clone()
+
+	if source.TLSConfig.IsSet() {
+		tlsConfig, err := newTLSConfig(source.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		t.TLSClientConfig = tlsConfig
+	}
+
+	if source.HTTPProxy != "" {
+		proxyURL, err := url.Parse(source.HTTPProxy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", resource.ErrInvalidProxy, err)
+		}
+
+		t.Proxy = proxyFunc(proxyURL, source.NoProxy)
+	}
+
+	return t, nil
+}
+
+func newTLSConfig(config resource.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CACert != "" {
+		// trust the CA as well as, not instead of, the system's CAs
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM([]byte(config.CACert)) {
+			return nil, fmt.Errorf("%w: ca_cert does not contain a PEM encoded certificate", resource.ErrInvalidTLSConfig)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(config.ClientCert), []byte(config.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", resource.ErrInvalidTLSConfig, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// proxyFunc returns a func for http.Transport.Proxy that sends requests through proxyURL,
+// except for requests to hosts matching an entry in noProxy. Entries are matched as in the
+// NO_PROXY environment variable: a host name also matches its subdomains, an IP address or
+// CIDR range matches the addresses in it, an entry with a port only matches that port, and
+// "*" matches every host
+func proxyFunc(proxyURL *url.URL, noProxy string) func(*http.Request) (*url.URL, error) {
+	var entries []string
+	for _, entry := range strings.Split(noProxy, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		port := req.URL.Port()
+		if port == "" {
+			port = map[string]string{"https": "443", "http": "80"}[req.URL.Scheme]
+		}
+
+		for _, entry := range entries {
+			if bypassProxy(entry, host, port) {
+				return nil, nil
+			}
+		}
+
+		return proxyURL, nil
+	}
+}
+
+func bypassProxy(entry string, host string, port string) bool {
+	if entry == "*" {
+		return true
+	}
+
+	if _, cidr, err := net.ParseCIDR(entry); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && cidr.Contains(ip)
+	}
+
+	if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+		if entryPort != port {
+			return false
+		}
+
+		entry = entryHost
+	}
+
+	if ip := net.ParseIP(entry); ip != nil {
+		return ip.Equal(net.ParseIP(host))
+	}
+
+	entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+	return host == entry || strings.HasSuffix(host, "."+entry)
+}
+
+// errorRoundTripper fails every request, for a client whose transport could not be configured
+type errorRoundTripper struct {
+	err error
+}
+
+func (e errorRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, e.err
+}
+
+// ErrUnsupportedTransport will be returned when TLS or proxy settings cannot be applied to a transport
+var ErrUnsupportedTransport = errors.New("unsupported transport")
@@ -0,0 +1,188 @@
+// Copyright 2020 VMware, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package wavefront_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	resource "github.com/vmware-tanzu/observability-event-resource"
+	"github.com/vmware-tanzu/observability-event-resource/internal/testutils"
+	"github.com/vmware-tanzu/observability-event-resource/wavefront"
+)
+
+func eventHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, httpOKResponse)
+}
+
+func serverCertPEM(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+func TestCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(eventHandler))
+	defer server.Close()
+
+	source := resource.Source{WavefrontURL: server.URL, WavefrontToken: "tls", Retry: resource.RetryConfig{MaxElapsedTime: "1ms"}}
+
+	if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err == nil {
+		t.Fatal("expected the server's certificate to be rejected without ca_cert, but it was not")
+	}
+
+	source.CACert = serverCertPEM(server)
+	if err := source.Validate(); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	source.CACert = ""
+	source.InsecureSkipVerify = true
+	if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	certPEM, keyPEM := newClientCert(t)
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(eventHandler))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	source := resource.Source{
+		WavefrontURL:   server.URL,
+		WavefrontToken: "mtls",
+		Retry:          resource.RetryConfig{MaxElapsedTime: "1ms"},
+		TLSConfig:      resource.TLSConfig{CACert: serverCertPEM(server)},
+	}
+
+	if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err == nil {
+		t.Fatal("expected the server to require a client certificate, but it did not")
+	}
+
+	source.ClientCert, source.ClientKey = string(certPEM), string(keyPEM)
+	if err := source.Validate(); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		eventHandler(w, r)
+	}))
+	defer proxy.Close()
+
+	source := resource.Source{
+		WavefrontURL:   "http://tenant.wavefront.invalid",
+		WavefrontToken: "proxy",
+		Retry:          resource.RetryConfig{MaxElapsedTime: "1ms"},
+		HTTPProxy:      proxy.URL,
+	}
+
+	if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	if len(proxied) != 1 || proxied[0] != "http://tenant.wavefront.invalid/api/v2/event/12345" {
+		t.Fatalf("expected the request to go through the proxy, but it saw %v", proxied)
+	}
+
+	for _, noProxy := range []string{"wavefront.invalid", "localhost, .invalid", "tenant.wavefront.invalid:80", "*"} {
+		source.NoProxy = noProxy
+		if _, err := wavefront.NewAPIClient(source, &http.Client{}).GetEvent(context.Background(), "12345"); err == nil {
+			t.Fatalf("expected the request to bypass the proxy with no_proxy %q, but it did not", noProxy)
+		}
+	}
+
+	if len(proxied) != 1 {
+		t.Fatalf("expected no_proxy hosts to bypass the proxy, but it saw %v", proxied)
+	}
+}
+
+func TestInvalidTransportConfig(t *testing.T) {
+	source := resource.Source{WavefrontURL: "https://foo", WavefrontToken: "bar"}
+
+	source.TLSConfig = resource.TLSConfig{CACert: "not a certificate"}
+	if err := source.Validate(); !errors.Is(err, resource.ErrInvalidTLSConfig) {
+		t.Fatalf("expected to get %v as an error but got %v", resource.ErrInvalidTLSConfig, err)
+	}
+
+	source.TLSConfig = resource.TLSConfig{ClientCert: "not a certificate"}
+	if err := source.Validate(); !errors.Is(err, resource.ErrInvalidTLSConfig) {
+		t.Fatalf("expected to get %v as an error but got %v", resource.ErrInvalidTLSConfig, err)
+	}
+
+	source.TLSConfig = resource.TLSConfig{}
+	source.HTTPProxy = "proxy:3128"
+	if err := source.Validate(); !errors.Is(err, resource.ErrInvalidProxy) {
+		t.Fatalf("expected to get %v as an error but got %v", resource.ErrInvalidProxy, err)
+	}
+
+	// settings cannot be applied to a transport that is not an *http.Transport
+	source.HTTPProxy = "http://proxy:3128"
+	hc := testutils.GetFakeHTTPClient(http.MethodGet, "/api/v2/event/12345", "bar", httpOKResponse)
+	if _, err := wavefront.NewAPIClient(source, hc).GetEvent(context.Background(), "12345"); !errors.Is(err, wavefront.ErrUnsupportedTransport) {
+		t.Fatalf("expected to get %v as an error but got %v", wavefront.ErrUnsupportedTransport, err)
+	}
+}
+
+// newClientCert returns a self-signed certificate for client authentication, and its key
+func newClientCert(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "concourse"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error occured: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}